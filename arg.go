@@ -0,0 +1,332 @@
+package ffi
+
+// #include <stdlib.h>
+// #include "ffi.h"
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// call_frame holds the C values of the arguments of an on-going call.
+// The pointers to the C values, and the scalar values themselves, are
+// allocated in C memory; the go memory they point to is pinned.
+type call_frame struct {
+	cargs  []unsafe.Pointer // pointers to the C values, as ffi_call expects
+	slots  unsafe.Pointer   // C values of the scalar arguments, 8 bytes each
+	cstrs  []unsafe.Pointer // C strings to release after the call
+	outs   []out_param      // output parameters to write back after the call
+	pinner runtime.Pinner   // pins the go memory passed to C
+}
+
+// out_param is an output parameter of an on-going call
+type out_param struct {
+	arg  int      // index of the argument
+	p    OutParam // the go value
+	cval Value    // the C value passed to the function
+}
+
+func new_call_frame(nargs int) *call_frame {
+	f := &call_frame{}
+	if nargs > 0 {
+		mem := C.malloc(C.size_t(uintptr(nargs) * (ptrSize + 8)))
+		f.cargs = (*[1 << 20]unsafe.Pointer)(mem)[:nargs:nargs]
+		f.slots = unsafe.Pointer(uintptr(mem) + uintptr(nargs)*ptrSize)
+	}
+	return f
+}
+
+// c_args returns the address of the pointers to the C values, as ffi_call
+// expects.
+func (f *call_frame) c_args() *unsafe.Pointer {
+	if len(f.cargs) == 0 {
+		return nil
+	}
+	return &f.cargs[0]
+}
+
+// free releases the C memory allocated for the call.
+func (f *call_frame) free() {
+	for _, cstr := range f.cstrs {
+		C.free(cstr)
+	}
+	f.cstrs = nil
+	f.pinner.Unpin()
+	if f.cargs != nil {
+		C.free(unsafe.Pointer(&f.cargs[0]))
+		f.cargs = nil
+	}
+}
+
+// set converts arg, the i-th argument of the call, into a C value of the
+// declared type t.
+// Only lossless conversions are performed.
+func (f *call_frame) set(i int, t Type, arg interface{}) error {
+	switch t.Kind() {
+	case Struct, Slice:
+		v, err := struct_arg(t, arg)
+		if err != nil {
+			return &CallError{i, err.Error()}
+		}
+		f.pinner.Pin(v.val)
+		f.cargs[i] = v.val
+		return nil
+	case Void, LongDouble:
+		return &CallError{i, fmt.Sprintf("unsupported argument type [%s]", t.Name())}
+	}
+
+	// scalars (and pointers) all fit in 8 bytes
+	p := unsafe.Pointer(uintptr(f.slots) + 8*uintptr(i))
+	f.cargs[i] = p
+
+	var err error
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		var n int64
+		n, err = int_arg(arg, t.Size())
+		store_int(p, t.Size(), uint64(n))
+	case Uint8, Uint16, Uint32, Uint64:
+		var n uint64
+		n, err = uint_arg(arg, t.Size())
+		store_int(p, t.Size(), n)
+	case Float:
+		var x float64
+		x, err = float_arg(arg, 24)
+		if err == nil && x == x && float64(float32(x)) != x {
+			err = fmt.Errorf("%v overflows or loses precision as a float", x)
+		}
+		*(*float32)(p) = float32(x)
+	case Double:
+		var x float64
+		x, err = float_arg(arg, 53)
+		*(*float64)(p) = x
+	case Ptr, Array:
+		err = f.ptr_arg(i, p, arg)
+	default:
+		err = fmt.Errorf("unsupported argument type [%s]", t.Name())
+	}
+	if err != nil {
+		if _, ok := err.(*CallError); ok {
+			return err
+		}
+		return &CallError{i, fmt.Sprintf("can not pass %T as [%s]: %v", arg, t.Name(), err)}
+	}
+	return nil
+}
+
+// ptr_arg stores the C pointer value of arg at p.
+func (f *call_frame) ptr_arg(i int, p unsafe.Pointer, arg interface{}) error {
+	if v, ok := arg.(uintptr); ok {
+		// an address the caller keeps valid
+		*(*uintptr)(p) = v
+		return nil
+	}
+	ptr, err := f.go_ptr_arg(i, arg)
+	if err != nil {
+		return err
+	}
+	if ptr != nil {
+		f.pinner.Pin(ptr)
+	}
+	*(*unsafe.Pointer)(p) = ptr
+	return nil
+}
+
+// go_ptr_arg returns the C pointer value of arg, which may point to go
+// memory.
+func (f *call_frame) go_ptr_arg(i int, arg interface{}) (unsafe.Pointer, error) {
+	switch v := arg.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		cstr := unsafe.Pointer(C.CString(v))
+		f.cstrs = append(f.cstrs, cstr)
+		return cstr, nil
+	case unsafe.Pointer:
+		return v, nil
+	case FctPtr:
+		return unsafe.Pointer(v.c), nil
+	case *Callback:
+		return v.Pointer(), nil
+	case OutParam:
+		cval, err := v.encode()
+		if err != nil {
+			return nil, &CallError{i, err.Error()}
+		}
+		f.outs = append(f.outs, out_param{i, v, cval})
+		return cval.val, nil
+	case Value:
+		if !v.IsValid() {
+			return nil, fmt.Errorf("invalid ffi.Value")
+		}
+		if v.Kind() == Ptr {
+			return *(*unsafe.Pointer)(v.val), nil
+		}
+		// pass the address of the value
+		return v.val, nil
+	}
+
+	rv := reflect.ValueOf(arg)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		return rv.UnsafePointer(), nil
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return nil, nil
+		}
+		return unsafe.Pointer(rv.Index(0).UnsafeAddr()), nil
+	}
+	return nil, fmt.Errorf("incompatible go type")
+}
+
+// struct_arg returns the C value of arg, an aggregate of type t.
+func struct_arg(t Type, arg interface{}) (cval Value, err error) {
+	if v, ok := arg.(Value); ok {
+		if !v.IsValid() || !same_layout(v.Type(), t) {
+			return Value{}, fmt.Errorf("ffi.Value of type [%s] can not be passed as [%s]",
+				value_type_name(v), t.Name())
+		}
+		return v, nil
+	}
+	if arg == nil || reflect.TypeOf(arg).Kind() != reflect.Struct {
+		return Value{}, fmt.Errorf("can not pass %T as [%s]", arg, t.Name())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("can not pass %T as [%s]: %v", arg, t.Name(), r)
+		}
+	}()
+	cval = New(t)
+	err = NewEncoder(cval).Encode(arg)
+	return cval, err
+}
+
+// int_arg converts arg into a signed integer of sz bytes.
+func int_arg(arg interface{}, sz uintptr) (int64, error) {
+	bits := 8 * sz
+	min := int64(-1) << (bits - 1)
+	max := -(min + 1)
+	rv := reflect.ValueOf(arg)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		if n < min || n > max {
+			return 0, fmt.Errorf("%d overflows", n)
+		}
+		return n, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		if n > uint64(max) {
+			return 0, fmt.Errorf("%d overflows", n)
+		}
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("incompatible go type")
+}
+
+// uint_arg converts arg into an unsigned integer of sz bytes.
+func uint_arg(arg interface{}, sz uintptr) (uint64, error) {
+	bits := 8 * sz
+	max := uint64(math.MaxUint64) >> (64 - bits)
+	rv := reflect.ValueOf(arg)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		if n < 0 || uint64(n) > max {
+			return 0, fmt.Errorf("%d overflows", n)
+		}
+		return uint64(n), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		if n > max {
+			return 0, fmt.Errorf("%d overflows", n)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("incompatible go type")
+}
+
+// float_arg converts arg into a floating point value.
+// Integers are accepted if they are exactly representable with a mantissa
+// of mant bits.
+func float_arg(arg interface{}, mant uint) (float64, error) {
+	limit := int64(1) << mant
+	rv := reflect.ValueOf(arg)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		if n < -limit || n > limit {
+			return 0, fmt.Errorf("%d loses precision", n)
+		}
+		return float64(n), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := rv.Uint()
+		if n > uint64(limit) {
+			return 0, fmt.Errorf("%d loses precision", n)
+		}
+		return float64(n), nil
+	}
+	return 0, fmt.Errorf("incompatible go type")
+}
+
+// store_int stores the sz lower bytes of x at p.
+func store_int(p unsafe.Pointer, sz uintptr, x uint64) {
+	switch sz {
+	case 1:
+		*(*uint8)(p) = uint8(x)
+	case 2:
+		*(*uint16)(p) = uint16(x)
+	case 4:
+		*(*uint32)(p) = uint32(x)
+	case 8:
+		*(*uint64)(p) = x
+	}
+}
+
+// result_buffer returns a buffer large enough to hold a C result of type t.
+// libffi widens integral results to a full ffi_arg.
+func result_buffer(t Type) unsafe.Pointer {
+	sz := max_uintptr(t.Size(), unsafe.Sizeof(C.ffi_arg(0)))
+	buf := make([]byte, sz)
+	return unsafe.Pointer(&buf[0])
+}
+
+// goresult returns the C result stored in buf, of type t, as returned by
+// Cif.Call.
+func goresult(t Type, buf unsafe.Pointer) reflect.Value {
+	rt := rtype_from_type(t)
+	if rt == g_value_type {
+		// aggregates are returned as a freshly allocated ffi.Value
+		return reflect.ValueOf(Value{t, buf, nil})
+	}
+	return goresult_from_c(t, buf, rt)
+}
+
+// goresult_from_c converts the C result stored in buf, of type t, into a go
+// value of type rt.
+func goresult_from_c(t Type, buf unsafe.Pointer, rt reflect.Type) reflect.Value {
+	out := reflect.New(rt).Elem()
+	narrow := t.Size() < unsafe.Sizeof(C.ffi_arg(0))
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		if narrow {
+			set_go_int(out, uint64(*(*C.ffi_sarg)(buf)))
+			return out
+		}
+	case Uint8, Uint16, Uint32, Uint64:
+		if narrow {
+			set_go_int(out, uint64(*(*C.ffi_arg)(buf)))
+			return out
+		}
+	}
+	memmove(unsafe.Pointer(out.UnsafeAddr()), buf, rt.Size())
+	return out
+}
+
+// EOF
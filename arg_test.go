@@ -0,0 +1,120 @@
+package ffi_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestArgConversions(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//double ldexp(double x, int exp);
+	ldexp, err := lib.Fct("ldexp", ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [ldexp]: %v", err)
+	}
+	//float ldexpf(float x, int exp);
+	ldexpf, err := lib.Fct("ldexpf", ffi.C_float, []ffi.Type{ffi.C_float, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [ldexpf]: %v", err)
+	}
+
+	type myint int
+
+	for _, table := range []struct {
+		x, exp interface{}
+		res    float64
+	}{
+		{1.5, int32(2), 6},
+		{float32(1.5), 2, 6},
+		{3, int8(-1), 1.5},
+		{uint16(3), uint8(1), 6},
+		{1.5, myint(1), 3},
+	} {
+		out, err := ldexp.Call(table.x, table.exp)
+		if err != nil {
+			t.Errorf("ldexp(%v, %v): unexpected error: %v", table.x, table.exp, err)
+			continue
+		}
+		eq(t, table.res, out.Float())
+
+		out, err = ldexpf.Call(table.x, table.exp)
+		if err != nil {
+			t.Errorf("ldexpf(%v, %v): unexpected error: %v", table.x, table.exp, err)
+			continue
+		}
+		eq(t, table.res, out.Float())
+	}
+
+	for _, table := range []struct {
+		fct    ffi.Function
+		x, exp interface{}
+		arg    int
+	}{
+		{ldexp, 1.5, int64(math.MaxInt32) + 1, 1},
+		{ldexp, 1.5, uint32(math.MaxUint32), 1},
+		{ldexp, 1.5, 2.0, 1},
+		{ldexp, "1.5", 2, 0},
+		{ldexp, int64(1)<<53 + 1, 2, 0},
+		{ldexpf, 0.1, 2, 0},
+		{ldexpf, math.MaxFloat64, 2, 0},
+		{ldexpf, 1 << 25, 2, 0},
+		{ldexp, nil, 2, 0},
+		{ldexp, 1.5, nil, 1},
+		{ldexp, 1.5, true, 1},
+	} {
+		_, err := table.fct.Call(table.x, table.exp)
+		if err == nil {
+			t.Errorf("expected an error calling with (%v, %v)", table.x, table.exp)
+			continue
+		}
+		cerr, ok := err.(*ffi.CallError)
+		if !ok {
+			t.Errorf("expected a *ffi.CallError, got %T (%v)", err, err)
+			continue
+		}
+		eq(t, table.arg, cerr.Arg)
+	}
+}
+
+func TestArgPointers(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//void *memset(void *s, int c, size_t n);
+	memset, err := lib.Fct("memset", ffi.C_pointer, []ffi.Type{ffi.C_pointer, ffi.C_int32, ffi.C_uint64})
+	if err != nil {
+		t.Fatalf("could not locate function [memset]: %v", err)
+	}
+
+	buf := make([]byte, 4)
+	memset(buf, 'x', 3)
+	eq(t, []byte("xxx\x00"), buf)
+
+	arr := [2]uint16{}
+	memset(&arr, 0xff, 4)
+	eq(t, [2]uint16{0xffff, 0xffff}, arr)
+
+	//int strcmp(const char* cs, const char* ct);
+	strcmp, err := lib.Fct("strcmp", ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [strcmp]: %v", err)
+	}
+	eq(t, int64(0), strcmp("foo", []byte("foo\x00")).Int())
+
+	_, err = strcmp.Call("foo", 42)
+	if err == nil {
+		t.Errorf("expected an error passing an int as a pointer")
+	}
+}
+
+// EOF
@@ -0,0 +1,384 @@
+package ffi
+
+// #include <stdlib.h>
+// #include "ffi.h"
+// typedef void (*_go_ffi_fctptr_t)(void);
+// extern void _go_ffi_callback(ffi_cif *cif, void *ret, void **args, void *data);
+// extern void _go_ffi_callback_entry(ffi_cif *cif, void *ret, void **args, void *data);
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// Callback is a go function callable from C through a ffi closure
+type Callback struct {
+	rtype   Type
+	args    []Type
+	c_cif   *C.ffi_cif // C-allocated, as the closure references it
+	fn      reflect.Value
+	closure *Closure
+	code    unsafe.Pointer // executable address of the closure
+	data    unsafe.Pointer // C-allocated id of the callback
+
+	mu    sync.Mutex
+	err   error
+	freed bool       // whether Free was called
+	libs  []*lib_ref // libraries the callback was passed to, see hold
+}
+
+// the global registry of live callbacks, indexed by id
+var g_callbacks = struct {
+	sync.RWMutex
+	id uintptr
+	m  map[uintptr]*Callback
+}{m: make(map[uintptr]*Callback)}
+
+var g_value_type = reflect.TypeOf(Value{})
+
+// NewCallback creates a C function pointer calling the go function fn.
+// rtype and args describe the C signature of the function pointer.
+// The Callback has to be released with Free once C code does not reference
+// it anymore.
+func NewCallback(fn interface{}, rtype Type, args []Type) (*Callback, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func {
+		return nil, fmt.Errorf("ffi.NewCallback: expected a func (got %T)", fn)
+	}
+	ft := rv.Type()
+	if ft.IsVariadic() || ft.NumIn() != len(args) {
+		return nil, fmt.Errorf("ffi.NewCallback: func type %s does not take %d arguments", ft, len(args))
+	}
+	for i, t := range args {
+		if !is_callback_compatible(t, ft.In(i)) {
+			return nil, fmt.Errorf("ffi.NewCallback: argument #%d of type [%s] can not be converted to %s", i, t.Name(), ft.In(i))
+		}
+	}
+	switch {
+	case rtype.Kind() == Void && ft.NumOut() != 0:
+		return nil, fmt.Errorf("ffi.NewCallback: func type %s returns a value, expected none", ft)
+	case rtype.Kind() != Void && ft.NumOut() != 1:
+		return nil, fmt.Errorf("ffi.NewCallback: func type %s should return exactly one value", ft)
+	case rtype.Kind() != Void && ft.Out(0).Kind() == reflect.String:
+		return nil, fmt.Errorf("ffi.NewCallback: callbacks can not return go strings")
+	case rtype.Kind() != Void && !is_callback_compatible(rtype, ft.Out(0)):
+		return nil, fmt.Errorf("ffi.NewCallback: result of type %s can not be converted to [%s]", ft.Out(0), rtype.Name())
+	}
+
+	c_cif, err := new_c_cif(DefaultAbi, rtype, args)
+	if err != nil {
+		return nil, err
+	}
+
+	cb := &Callback{rtype: rtype, args: args, c_cif: c_cif, fn: rv}
+	cl := C.ffi_closure_alloc(C.size_t(unsafe.Sizeof(C.ffi_closure{})), &cb.code)
+	if cl == nil {
+		free_c_cif(c_cif)
+		return nil, fmt.Errorf("ffi.NewCallback: could not allocate closure")
+	}
+	cb.closure = (*Closure)(cl)
+
+	g_callbacks.Lock()
+	g_callbacks.id++
+	id := g_callbacks.id
+	g_callbacks.m[id] = cb
+	g_callbacks.Unlock()
+
+	cb.data = C.malloc(C.size_t(unsafe.Sizeof(id)))
+	*(*uintptr)(cb.data) = id
+
+	sc := C.ffi_prep_closure_loc(&cb.closure.c, c_cif, (*[0]byte)(C._go_ffi_callback_entry), cb.data, cb.code)
+	if sc != C.FFI_OK {
+		cb.Free()
+		return nil, fmt.Errorf("ffi.NewCallback: error while preparing closure (%s)", Status(sc))
+	}
+	return cb, nil
+}
+
+// new_c_cif prepares a cif allocated, with its argument types, by C.malloc.
+// It has to be released with free_c_cif.
+func new_c_cif(abi Abi, rtype Type, args []Type) (*C.ffi_cif, error) {
+	c_cif := (*C.ffi_cif)(C.malloc(C.size_t(unsafe.Sizeof(C.ffi_cif{}))))
+	sc := C.ffi_prep_cif(c_cif, C.ffi_abi(abi), C.uint(len(args)), rtype.cptr(), c_arg_types(args))
+	if sc != C.FFI_OK {
+		free_c_cif(c_cif)
+		return nil, fmt.Errorf("error while preparing cif (%s)", Status(sc))
+	}
+	return c_cif, nil
+}
+
+// free_c_cif releases a cif allocated by new_c_cif.
+func free_c_cif(c_cif *C.ffi_cif) {
+	C.free(unsafe.Pointer(c_cif.arg_types))
+	C.free(unsafe.Pointer(c_cif))
+}
+
+// FctPtr returns the C function pointer calling into the callback.
+func (cb *Callback) FctPtr() FctPtr {
+	return FctPtr{(C._go_ffi_fctptr_t)(cb.code), nil}
+}
+
+// Pointer returns the address of the C function pointer calling into the
+// callback.
+func (cb *Callback) Pointer() unsafe.Pointer {
+	return cb.code
+}
+
+// Err returns the last error recorded while running the callback, e.g. a
+// go panic.
+func (cb *Callback) Err() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.err
+}
+
+// hold keeps the library st loaded until the callback is freed.
+func (cb *Callback) hold(st *lib_state) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.freed {
+		return
+	}
+	for _, ref := range cb.libs {
+		if ref.st == st {
+			return
+		}
+	}
+	if ref := st.ref(); ref != nil {
+		cb.libs = append(cb.libs, ref)
+	}
+}
+
+// Free releases the resources associated with the callback, and the
+// libraries it was passed to (see Library.Close).
+// The C function pointer must not be called afterwards.
+func (cb *Callback) Free() error {
+	cb.mu.Lock()
+	freed := cb.freed
+	cb.freed = true
+	libs := cb.libs
+	cb.libs = nil
+	cb.mu.Unlock()
+	if freed {
+		return fmt.Errorf("ffi.Callback.Free: callback already freed")
+	}
+	for _, ref := range libs {
+		ref.release()
+	}
+	// calls racing with Free find the callback freed, see call
+	C.ffi_closure_free(unsafe.Pointer(cb.closure))
+	g_callbacks.Lock()
+	delete(g_callbacks.m, *(*uintptr)(cb.data))
+	g_callbacks.Unlock()
+
+	C.free(cb.data)
+	free_c_cif(cb.c_cif)
+	cb.closure = nil
+	cb.code = nil
+	cb.data = nil
+	cb.c_cif = nil
+	return nil
+}
+
+//export _go_ffi_callback
+func _go_ffi_callback(c_cif *C.ffi_cif, ret unsafe.Pointer, args *unsafe.Pointer, data unsafe.Pointer) {
+	id := *(*uintptr)(data)
+	g_callbacks.RLock()
+	cb := g_callbacks.m[id]
+	g_callbacks.RUnlock()
+	if cb == nil {
+		// do not unwind a go panic through the C frames of the caller
+		clear_result(c_cif, ret)
+		return
+	}
+	cb.call(c_cif, ret, args)
+}
+
+// clear_result zeroes ret, the result slot of a call through c_cif.
+func clear_result(c_cif *C.ffi_cif, ret unsafe.Pointer) {
+	if c_cif.rtype._type == C.FFI_TYPE_VOID {
+		return
+	}
+	memclr(ret, max_uintptr(uintptr(c_cif.rtype.size), unsafe.Sizeof(C.ffi_arg(0))))
+}
+
+// call runs the go function of the callback with the C arguments args,
+// storing its result into ret.
+func (cb *Callback) call(c_cif *C.ffi_cif, ret unsafe.Pointer, args *unsafe.Pointer) {
+	// make sure C gets a well defined result, even if fn panics.
+	clear_result(c_cif, ret)
+
+	cb.mu.Lock()
+	freed := cb.freed
+	if freed {
+		cb.err = fmt.Errorf("ffi: call of freed callback")
+	}
+	cb.mu.Unlock()
+	if freed {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			cb.mu.Lock()
+			cb.err = fmt.Errorf("ffi: panic in callback: %v", r)
+			cb.mu.Unlock()
+		}
+	}()
+
+	ft := cb.fn.Type()
+	nargs := len(cb.args)
+	var cargs []unsafe.Pointer
+	if nargs > 0 {
+		cargs = (*[1 << 20]unsafe.Pointer)(unsafe.Pointer(args))[:nargs:nargs]
+	}
+	in := make([]reflect.Value, nargs)
+	for i, t := range cb.args {
+		in[i] = goarg_from_c(Value{t, cargs[i], nil}, ft.In(i))
+	}
+
+	rtype := cb.rtype
+	out := cb.fn.Call(in)
+	if rtype.Kind() != Void {
+		err := cresult_from_go(rtype, ret, out[0])
+		if err != nil {
+			cb.mu.Lock()
+			cb.err = err
+			cb.mu.Unlock()
+		}
+	}
+}
+
+// is_callback_compatible returns whether values of type t can be converted
+// from (and to) go values of type rt by a Callback.
+func is_callback_compatible(t Type, rt reflect.Type) bool {
+	if rt == g_value_type {
+		return true
+	}
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64:
+		switch rt.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Bool:
+			return true
+		}
+	case Float, Double:
+		switch rt.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return true
+		}
+	case Ptr:
+		switch rt.Kind() {
+		case reflect.Uintptr, reflect.UnsafePointer, reflect.String:
+			return true
+		}
+	}
+	return false
+}
+
+// goarg_from_c converts the C value v into a go value of type rt.
+func goarg_from_c(v Value, rt reflect.Type) reflect.Value {
+	if rt == g_value_type {
+		return reflect.ValueOf(v)
+	}
+	out := reflect.New(rt).Elem()
+	switch v.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		set_go_int(out, uint64(v.Int()))
+	case Uint8, Uint16, Uint32, Uint64:
+		set_go_int(out, v.Uint())
+	case Float, Double:
+		out.SetFloat(v.Float())
+	case Ptr:
+		ptr := *(*unsafe.Pointer)(v.val)
+		switch rt.Kind() {
+		case reflect.Uintptr:
+			out.SetUint(uint64(uintptr(ptr)))
+		case reflect.UnsafePointer:
+			out.SetPointer(ptr)
+		case reflect.String:
+			if ptr != nil {
+				out.SetString(C.GoString((*C.char)(ptr)))
+			}
+		}
+	}
+	return out
+}
+
+// set_go_int sets the integer (or boolean) go value rv to x.
+func set_go_int(rv reflect.Value, x uint64) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(int64(x))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rv.SetUint(x)
+	case reflect.Bool:
+		rv.SetBool(x != 0)
+	}
+}
+
+// cresult_from_go stores the go value rv into ret, the result slot of a C
+// function returning a value of type t.
+// Integral results are widened to the size of a ffi_arg, as libffi expects.
+// A Value is only stored if it has the layout of t.
+func cresult_from_go(t Type, ret unsafe.Pointer, rv reflect.Value) error {
+	if rv.Type() == g_value_type {
+		v := rv.Interface().(Value)
+		if !same_layout(v.typ, t) {
+			return &CallError{-1, fmt.Sprintf("result of type [%s] can not be returned as [%s]", value_type_name(v), t.Name())}
+		}
+		memmove(ret, v.val, t.Size())
+		return nil
+	}
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		*(*C.ffi_sarg)(ret) = C.ffi_sarg(int64(go_int(rv)))
+	case Uint8, Uint16, Uint32, Uint64:
+		*(*C.ffi_arg)(ret) = C.ffi_arg(go_int(rv))
+	case Float:
+		*(*float32)(ret) = float32(rv.Float())
+	case Double:
+		*(*float64)(ret) = rv.Float()
+	case Ptr:
+		switch rv.Kind() {
+		case reflect.Uintptr:
+			*(*uintptr)(ret) = uintptr(rv.Uint())
+		case reflect.UnsafePointer:
+			*(*unsafe.Pointer)(ret) = unsafe.Pointer(rv.Pointer())
+		}
+	}
+	return nil
+}
+
+// go_int returns the integer (or boolean) go value rv as a uint64.
+func go_int(rv reflect.Value) uint64 {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(rv.Int())
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+		return 0
+	}
+	return rv.Uint()
+}
+
+func memclr(ptr unsafe.Pointer, n uintptr) {
+	for i := uintptr(0); i < n; i++ {
+		*(*byte)(unsafe.Pointer(uintptr(ptr) + i)) = 0
+	}
+}
+
+func max_uintptr(a, b uintptr) uintptr {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// EOF
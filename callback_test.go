@@ -0,0 +1,168 @@
+package ffi_test
+
+import (
+	"sort"
+	"testing"
+	"unsafe"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestCallbackQsort(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//void qsort(void *base, size_t nmemb, size_t size,
+	//           int (*compar)(const void *, const void *));
+	qsort, err := lib.Fct("qsort", ffi.C_void,
+		[]ffi.Type{ffi.C_pointer, ffi.C_uint64, ffi.C_uint64, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [qsort]: %v", err)
+	}
+
+	ncalls := 0
+	cmp, err := ffi.NewCallback(
+		func(a, b unsafe.Pointer) int32 {
+			ncalls++
+			return *(*int32)(a) - *(*int32)(b)
+		},
+		ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_pointer},
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cmp.Free()
+
+	data := []int32{5, -2, 42, 0, 7, 3}
+	ref := append([]int32(nil), data...)
+	sort.Slice(ref, func(i, j int) bool { return ref[i] < ref[j] })
+
+	qsort(unsafe.Pointer(&data[0]), uint64(len(data)), uint64(4), cmp.FctPtr())
+	eq(t, ref, data)
+	if ncalls == 0 {
+		t.Errorf("callback was not called")
+	}
+	if err := cmp.Err(); err != nil {
+		t.Errorf("unexpected callback error: %v", err)
+	}
+}
+
+func TestCallbackCall(t *testing.T) {
+	cb, err := ffi.NewCallback(
+		func(x float64, n int32) float64 {
+			return x * float64(n)
+		},
+		ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_int32},
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cb.Free()
+
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err := cif.Call(cb.FctPtr(), 1.5, int32(4))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 6.0, out.Float())
+}
+
+func TestCallbackPanic(t *testing.T) {
+	cb, err := ffi.NewCallback(
+		func(n int32) int32 {
+			panic("boom")
+		},
+		ffi.C_int32, []ffi.Type{ffi.C_int32},
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cb.Free()
+
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err := cif.Call(cb.FctPtr(), int32(4))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, int64(0), out.Int())
+	if cb.Err() == nil {
+		t.Errorf("expected the panic to be recorded")
+	}
+}
+
+func TestCallbackResultLayout(t *testing.T) {
+	quad, err := ffi.NewStructType("callback_quad", []ffi.Field{
+		{"a", ffi.C_double}, {"b", ffi.C_double}, {"c", ffi.C_double}, {"d", ffi.C_double},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cb, err := ffi.NewCallback(
+		func() ffi.Value {
+			return ffi.New(ffi.C_int8)
+		},
+		quad, nil,
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cb.Free()
+
+	cif, err := ffi.NewCif(ffi.DefaultAbi, quad, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err := cif.Call(cb.FctPtr())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// the result is not read past the end of the returned Value
+	eq(t, 0.0, out.Interface().(ffi.Value).Field(3).Float())
+	if _, ok := cb.Err().(*ffi.CallError); !ok {
+		t.Errorf("expected a *ffi.CallError returning a Value of another type (got %v)", cb.Err())
+	}
+}
+
+func TestCallbackInvalid(t *testing.T) {
+	for _, table := range []struct {
+		fn    interface{}
+		rtype ffi.Type
+		args  []ffi.Type
+	}{
+		{42, ffi.C_void, nil},
+		{func(int32) {}, ffi.C_void, nil},
+		{func(float64) {}, ffi.C_void, []ffi.Type{ffi.C_int32}},
+		{func() {}, ffi.C_int32, nil},
+		{func() int32 { return 0 }, ffi.C_void, nil},
+		{func() string { return "" }, ffi.C_pointer, nil},
+	} {
+		_, err := ffi.NewCallback(table.fn, table.rtype, table.args)
+		if err == nil {
+			t.Errorf("expected an error creating callback from %T", table.fn)
+		}
+	}
+
+	cb, err := ffi.NewCallback(func() {}, ffi.C_void, nil)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	err = cb.Free()
+	if err != nil {
+		t.Errorf("unexpected error freeing callback: %v", err)
+	}
+	err = cb.Free()
+	if err == nil {
+		t.Errorf("expected an error freeing callback twice")
+	}
+}
+
+// EOF
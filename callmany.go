@@ -0,0 +1,234 @@
+package ffi
+
+// #include <stdint.h>
+// #include <stdlib.h>
+// #include <string.h>
+// #include "ffi.h"
+// typedef void (*_go_ffi_fctptr_t)(void);
+// static void _go_ffi_store_result(void *dst, void *src, ffi_type *t)
+// {
+//   switch (t->type) {
+//   case FFI_TYPE_UINT8:  *(uint8_t*)dst  = (uint8_t)*(ffi_arg*)src;   break;
+//   case FFI_TYPE_SINT8:  *(int8_t*)dst   = (int8_t)*(ffi_sarg*)src;   break;
+//   case FFI_TYPE_UINT16: *(uint16_t*)dst = (uint16_t)*(ffi_arg*)src;  break;
+//   case FFI_TYPE_SINT16: *(int16_t*)dst  = (int16_t)*(ffi_sarg*)src;  break;
+//   case FFI_TYPE_UINT32: *(uint32_t*)dst = (uint32_t)*(ffi_arg*)src;  break;
+//   case FFI_TYPE_INT:
+//   case FFI_TYPE_SINT32: *(int32_t*)dst  = (int32_t)*(ffi_sarg*)src;  break;
+//   default:
+//     memcpy(dst, src, t->size);
+//   }
+// }
+// // _go_ffi_call_many returns -1 if the buffers of the calls can not be
+// // allocated, 0 otherwise.
+// static int _go_ffi_call_many(ffi_cif *cif, _go_ffi_fctptr_t fn, size_t n,
+//                              void *out, size_t ostride,
+//                              void **bases, size_t *strides)
+// {
+//   size_t i, j;
+//   size_t rsize = cif->rtype->size;
+//   void **avalue = NULL;
+//   void *rvalue = NULL;
+//   if (rsize < sizeof(ffi_arg)) rsize = sizeof(ffi_arg);
+//   rvalue = malloc(rsize);
+//   if (cif->nargs > 0) avalue = malloc(cif->nargs * sizeof(void*));
+//   if (rvalue == NULL || (cif->nargs > 0 && avalue == NULL)) {
+//     free(avalue);
+//     free(rvalue);
+//     return -1;
+//   }
+//   for (i = 0; i < n; i++) {
+//     for (j = 0; j < cif->nargs; j++) {
+//       avalue[j] = (char*)bases[j] + i*strides[j];
+//     }
+//     ffi_call(cif, fn, rvalue, avalue);
+//     if (out) {
+//       _go_ffi_store_result((char*)out + i*ostride, rvalue, cif->rtype);
+//     }
+//   }
+//   free(avalue);
+//   free(rvalue);
+//   return 0;
+// }
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// CallMany calls fct once per element of its slice arguments, storing the
+// i-th result into the i-th element of out.
+// Each argument is either a go slice (or a Slice/Array ffi.Value) whose
+// elements have the memory layout of the declared type, or a scalar passed
+// unchanged to every call. Pointer arguments are only iterated over when
+// given as []uintptr or []unsafe.Pointer.
+// out is a slice (or a Slice/Array ffi.Value) of the result type, or nil to
+// discard the results.
+// The loop over the elements runs in C, with a single go/C transition and
+// reused argument buffers.
+func (cif *Cif) CallMany(fct FctPtr, out interface{}, args ...interface{}) error {
+	nargs := len(args)
+	if nargs != int(cif.c.nargs) {
+		return &CallError{-1, fmt.Sprintf(
+			"invalid number of arguments. expected '%d', got '%d'.",
+			int(cif.c.nargs), nargs)}
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	frame := new_call_frame(nargs)
+	defer frame.free()
+
+	n := -1
+	c_bases := (*[1 << 20]unsafe.Pointer)(C.malloc(C.size_t(ptrSize * uintptr(nargs+1))))[: nargs+1 : nargs+1]
+	defer C.free(unsafe.Pointer(&c_bases[0]))
+	c_strides := (*[1 << 20]C.size_t)(C.malloc(C.size_t(unsafe.Sizeof(C.size_t(0)) * uintptr(nargs+1))))[: nargs+1 : nargs+1]
+	defer C.free(unsafe.Pointer(&c_strides[0]))
+
+	for i, arg := range args {
+		t := cif.args[i]
+		base, stride, sz, err := many_arg(t, arg)
+		if err != nil {
+			return &CallError{i, err.Error()}
+		}
+		if sz < 0 {
+			// broadcast scalar
+			err = frame.set(i, t, arg)
+			if err != nil {
+				return err
+			}
+			base = frame.cargs[i]
+		} else {
+			if n >= 0 && sz != n {
+				return &CallError{i, fmt.Sprintf("invalid length. expected '%d', got '%d'.", n, sz)}
+			}
+			n = sz
+		}
+		if base != nil {
+			pinner.Pin(base)
+		}
+		c_bases[i] = base
+		c_strides[i] = C.size_t(stride)
+	}
+	if len(frame.outs) > 0 {
+		return &CallError{frame.outs[0].arg, "output parameters are not supported by CallMany"}
+	}
+
+	var (
+		c_out    unsafe.Pointer
+		c_stride uintptr
+	)
+	if out != nil && cif.rtype.Kind() != Void {
+		base, stride, sz, err := many_arg(cif.rtype, out)
+		if err != nil {
+			return &CallError{-1, "result: " + err.Error()}
+		}
+		if sz < 0 {
+			return &CallError{-1, fmt.Sprintf("result: expected a slice (got %T)", out)}
+		}
+		if n >= 0 && sz != n {
+			return &CallError{-1, fmt.Sprintf("result: invalid length. expected '%d', got '%d'.", n, sz)}
+		}
+		n = sz
+		c_out, c_stride = base, stride
+		if c_out != nil {
+			pinner.Pin(c_out)
+		}
+	}
+	if n < 0 {
+		return &CallError{-1, "no slice argument nor result"}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	rc := C._go_ffi_call_many(&cif.c, fct.c, C.size_t(n),
+		c_out, C.size_t(c_stride),
+		&c_bases[0], &c_strides[0])
+	runtime.KeepAlive(args)
+	runtime.KeepAlive(out)
+	if rc != 0 {
+		return &CallError{-1, "could not allocate the buffers of the calls"}
+	}
+	return nil
+}
+
+// many_arg returns the base address, stride and length of arg, a slice
+// of C values of type t.
+// A negative length is returned if arg is not a slice of such values.
+func many_arg(t Type, arg interface{}) (base unsafe.Pointer, stride uintptr, n int, err error) {
+	if v, ok := arg.(Value); ok {
+		if !v.IsValid() {
+			return nil, 0, -1, nil
+		}
+		switch v.Kind() {
+		case Slice, Array:
+		default:
+			return nil, 0, -1, nil
+		}
+		et := v.Type().Elem()
+		if !same_layout(et, t) {
+			return nil, 0, 0, fmt.Errorf("can not iterate over [%s] as [%s]", v.Type().Name(), t.Name())
+		}
+		n = v.Len()
+		if n > 0 {
+			base = v.Index(0).val
+		}
+		return base, et.Size(), n, nil
+	}
+
+	rv := reflect.ValueOf(arg)
+	if rv.Kind() != reflect.Slice {
+		return nil, 0, -1, nil
+	}
+	et := rv.Type().Elem()
+	if t.Kind() == Ptr && et.Kind() != reflect.Uintptr && et.Kind() != reflect.UnsafePointer {
+		// e.g. a []byte buffer passed to all calls
+		return nil, 0, -1, nil
+	}
+	if !is_layout_compatible(t, et) {
+		return nil, 0, 0, fmt.Errorf("can not iterate over %s as [%s]", rv.Type(), t.Name())
+	}
+	n = rv.Len()
+	if n > 0 {
+		base = unsafe.Pointer(rv.Index(0).UnsafeAddr())
+	}
+	return base, et.Size(), n, nil
+}
+
+// is_layout_compatible returns whether go values of type rt have the memory
+// layout of C values of type t.
+func is_layout_compatible(t Type, rt reflect.Type) bool {
+	if rt.Size() != t.Size() {
+		return false
+	}
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		switch rt.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return true
+		}
+	case Uint8, Uint16, Uint32, Uint64:
+		switch rt.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+	case Float, Double:
+		switch rt.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return true
+		}
+	case Ptr:
+		switch rt.Kind() {
+		case reflect.Uintptr, reflect.UnsafePointer:
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -0,0 +1,180 @@
+package ffi_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestCallMany(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//double erf(double x);
+	erf, err := lib.FctPtr("erf")
+	if err != nil {
+		t.Fatalf("could not locate function [erf]: %v", err)
+	}
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	xs := make([]float64, 100)
+	for i := range xs {
+		xs[i] = float64(i-50) / 10
+	}
+	out := make([]float64, len(xs))
+	err = cif.CallMany(erf, out, xs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, x := range xs {
+		eq(t, math.Erf(x), out[i])
+	}
+
+	//double ldexp(double x, int exp);
+	ldexp, err := lib.FctPtr("ldexp")
+	if err != nil {
+		t.Fatalf("could not locate function [ldexp]: %v", err)
+	}
+	cif, err = ffi.NewCif(ffi.DefaultAbi, ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// scalar arguments are passed to every call
+	err = cif.CallMany(ldexp, out, xs, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, x := range xs {
+		eq(t, 4*x, out[i])
+	}
+
+	exps := []int32{0, 1, 2, 3}
+	err = cif.CallMany(ldexp, out[:4], 1.5, exps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, []float64{1.5, 3, 6, 12}, out[:4])
+
+	// ffi.Value slices
+	arrtyp, err := ffi.NewArrayType(4, ffi.C_int32)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	carr := ffi.New(arrtyp)
+	err = ffi.NewEncoder(carr).Encode([4]int32{3, 2, 1, 0})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = cif.CallMany(ldexp, out[:4], 1.5, carr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, []float64{12, 6, 3, 1.5}, out[:4])
+
+	for _, table := range []struct {
+		out  interface{}
+		args []interface{}
+		arg  int
+	}{
+		{out, []interface{}{xs[:2], exps}, 1},
+		{out[:2], []interface{}{xs, 2}, -1},
+		{out, []interface{}{xs, []int{1}}, 1},
+		{[]float32{0}, []interface{}{1.5, 2}, -1},
+		{nil, []interface{}{1.5, 2}, -1},
+		{out, []interface{}{xs}, -1},
+	} {
+		err = cif.CallMany(ldexp, table.out, table.args...)
+		if err == nil {
+			t.Errorf("expected an error (args=%v)", table.args)
+			continue
+		}
+		cerr, ok := err.(*ffi.CallError)
+		if !ok {
+			t.Errorf("expected a *ffi.CallError, got %T (%v)", err, err)
+			continue
+		}
+		eq(t, table.arg, cerr.Arg)
+	}
+}
+
+func TestCallManyNarrowResult(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//int abs(int j);
+	abs, err := lib.FctPtr("abs")
+	if err != nil {
+		t.Fatalf("could not locate function [abs]: %v", err)
+	}
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the results are written next to each other, without their ffi_arg
+	// widening.
+	out := []int32{-1, -1, -1, -1, -1}
+	err = cif.CallMany(abs, out[:4], []int32{-3, 2, -1, 0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, []int32{3, 2, 1, 0, -1}, out)
+}
+
+func BenchmarkCall(b *testing.B) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	erf, err := lib.Fct("erf", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	xs := make([]float64, 1024)
+	out := make([]float64, len(xs))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, x := range xs {
+			out[j] = erf(x).Float()
+		}
+	}
+}
+
+func BenchmarkCallMany(b *testing.B) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	erf, err := lib.FctPtr("erf")
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	xs := make([]float64, 1024)
+	out := make([]float64, len(xs))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = cif.CallMany(erf, out, xs)
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+// EOF
@@ -1,6 +1,7 @@
 package ffi
 
 // #cgo pkg-config: libffi
+// #cgo linux LDFLAGS: -ldl
 // #include "ffi.h"
 import "C"
 
@@ -0,0 +1,123 @@
+package ffi
+
+// #define _GNU_SOURCE
+// #include <dlfcn.h>
+// #include <stdlib.h>
+// #include <string.h>
+//
+// // _go_ffi_dlopen dl-opens the library fname, or returns NULL and sets err
+// // to an error message to be freed.
+// static void *_go_ffi_dlopen(const char *fname, int flags, char **err)
+// {
+//   const char *msg = NULL;
+//   void *h = dlopen(fname, flags);
+//   if (h == NULL) {
+//     msg = dlerror();
+//     *err = strdup(msg != NULL ? msg : "could not open library");
+//   }
+//   return h;
+// }
+//
+// // _go_ffi_dlclose dl-closes the handle h, or returns an error message to
+// // be freed.
+// static char *_go_ffi_dlclose(void *h)
+// {
+//   const char *msg = NULL;
+//   if (dlclose(h) == 0) {
+//     return NULL;
+//   }
+//   msg = dlerror();
+//   return strdup(msg != NULL ? msg : "could not close library");
+// }
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// LibraryFlags are the dlopen mode flags of a library.
+type LibraryFlags int
+
+const (
+	// RtldLazy resolves the functions of the library on their first call.
+	RtldLazy LibraryFlags = C.RTLD_LAZY
+	// RtldNow resolves all the functions of the library when it is loaded.
+	RtldNow LibraryFlags = C.RTLD_NOW
+	// RtldGlobal makes the symbols of the library available to the
+	// subsequently loaded libraries.
+	RtldGlobal LibraryFlags = C.RTLD_GLOBAL
+	// RtldLocal keeps the symbols of the library to itself (the default).
+	RtldLocal LibraryFlags = C.RTLD_LOCAL
+	// RtldNoDelete keeps the library loaded after it is closed.
+	RtldNoDelete LibraryFlags = C.RTLD_NODELETE
+	// RtldNoLoad only succeeds if the library is already loaded.
+	RtldNoLoad LibraryFlags = C.RTLD_NOLOAD
+)
+
+// NewLibraryWithFlags is like NewLibrary, opening the library with the
+// given dlopen mode flags. RtldNow is implied if neither RtldLazy nor
+// RtldNow is given.
+func NewLibraryWithFlags(libname string, flags LibraryFlags) (*Library, error) {
+	if flags&(RtldLazy|RtldNow) == 0 {
+		flags |= RtldNow
+	}
+	lerr := &LibraryError{Name: libname}
+	for _, fname := range resolve_library(libname) {
+		h, err := dl_open(fname, flags)
+		if err == nil {
+			return &Library{
+				handle: h,
+				path:   loaded_path(fname),
+				state:  new_lib_state(h.close),
+			}, nil
+		}
+		lerr.Tried = append(lerr.Tried, fname)
+		lerr.Errs = append(lerr.Errs, err)
+	}
+	return nil, lerr
+}
+
+// IsLoaded returns whether the library libname, resolved as NewLibrary
+// does, is already loaded in the process.
+func IsLoaded(libname string) bool {
+	for _, fname := range resolve_library(libname) {
+		h, err := dl_open(fname, RtldLazy|RtldNoLoad)
+		if err == nil {
+			// release the reference taken by dlopen
+			h.close()
+			return true
+		}
+	}
+	return false
+}
+
+// dl_handle is the dlopen handle of a library.
+type dl_handle struct {
+	c unsafe.Pointer
+}
+
+// dl_open dl-opens the library fname with the dlopen mode flags.
+func dl_open(fname string, flags LibraryFlags) (dl_handle, error) {
+	c_fname := C.CString(fname)
+	defer C.free(unsafe.Pointer(c_fname))
+	var c_err *C.char
+	h := C._go_ffi_dlopen(c_fname, C.int(flags), &c_err)
+	if h == nil {
+		defer C.free(unsafe.Pointer(c_err))
+		return dl_handle{}, errors.New(C.GoString(c_err))
+	}
+	return dl_handle{h}, nil
+}
+
+// close dl-closes the handle.
+func (h dl_handle) close() error {
+	c_err := C._go_ffi_dlclose(h.c)
+	if c_err != nil {
+		defer C.free(unsafe.Pointer(c_err))
+		return errors.New(C.GoString(c_err))
+	}
+	return nil
+}
+
+// EOF
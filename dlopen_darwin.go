@@ -0,0 +1,22 @@
+package ffi
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// RtldDeepBind is a no-op: the two-level namespace of darwin already binds
+// the symbols of a library to the libraries it was linked against.
+const RtldDeepBind LibraryFlags = 0
+
+// loaded_path returns the path of the file of the loaded library fname.
+func loaded_path(fname string) string {
+	return fname
+}
+
+// dlvsym returns an error: darwin has no symbol versions.
+func (lib Library) dlvsym(name, version string) (unsafe.Pointer, error) {
+	return nil, fmt.Errorf("ffi: no symbol versions on darwin (%s@%s)", name, version)
+}
+
+// EOF
@@ -0,0 +1,84 @@
+package ffi
+
+// #define _GNU_SOURCE
+// #include <dlfcn.h>
+// #include <link.h>
+// #include <stdlib.h>
+// #include <string.h>
+//
+// // _go_ffi_dl_path returns the path of the loaded library fname, to be
+// // freed, or NULL.
+// static char *_go_ffi_dl_path(const char *fname)
+// {
+//   struct link_map *lm = NULL;
+//   char *path = NULL;
+//   void *h = dlopen(fname, RTLD_LAZY | RTLD_NOLOAD);
+//   if (h == NULL) {
+//     return NULL;
+//   }
+//   if (dlinfo(h, RTLD_DI_LINKMAP, &lm) == 0 && lm != NULL &&
+//       lm->l_name != NULL && lm->l_name[0] != '\0') {
+//     path = strdup(lm->l_name);
+//   }
+//   dlclose(h);
+//   return path;
+// }
+//
+// // _go_ffi_dlvsym returns the address of the version version of the
+// // symbol name of the handle h. It returns NULL and an error message to be
+// // freed if the symbol is not found.
+// static void *_go_ffi_dlvsym(void *h, const char *name, const char *version, char **err)
+// {
+//   const char *msg = NULL;
+//   void *sym = NULL;
+//   dlerror();
+//   sym = dlvsym(h, name, version);
+//   if (sym == NULL) {
+//     msg = dlerror();
+//     *err = strdup(msg != NULL ? msg : "symbol not found");
+//   }
+//   return sym;
+// }
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// RtldDeepBind makes the library resolve its symbols in itself before the
+// global scope, isolating it from conflicting copies of the libraries it
+// uses.
+const RtldDeepBind LibraryFlags = C.RTLD_DEEPBIND
+
+// loaded_path returns the path of the file of the loaded library fname, as
+// found by the dynamic linker.
+func loaded_path(fname string) string {
+	c_fname := C.CString(fname)
+	defer C.free(unsafe.Pointer(c_fname))
+	c_path := C._go_ffi_dl_path(c_fname)
+	if c_path == nil {
+		return fname
+	}
+	defer C.free(unsafe.Pointer(c_path))
+	return C.GoString(c_path)
+}
+
+// dlvsym returns the address of the version version of the symbol name of
+// lib.
+func (lib Library) dlvsym(name, version string) (unsafe.Pointer, error) {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	c_version := C.CString(version)
+	defer C.free(unsafe.Pointer(c_version))
+
+	var c_err *C.char
+	sym := C._go_ffi_dlvsym(lib.dlsym_handle(), c_name, c_version, &c_err)
+	if sym == nil {
+		defer C.free(unsafe.Pointer(c_err))
+		return nil, errors.New(C.GoString(c_err))
+	}
+	return sym, nil
+}
+
+// EOF
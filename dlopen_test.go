@@ -0,0 +1,53 @@
+package ffi_test
+
+import (
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestNewLibraryWithFlags(t *testing.T) {
+	lib, err := ffi.NewLibraryWithFlags(libm_name, ffi.RtldLazy|ffi.RtldGlobal|ffi.RtldDeepBind)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	cos, err := lib.Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 1.0, cos(0.0).Float())
+
+	// already loaded: RtldNoLoad succeeds
+	lib2, err := ffi.NewLibraryWithFlags(libm_name, ffi.RtldNoLoad)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lib2.Close()
+
+	_, err = ffi.NewLibraryWithFlags("no-such-ffi-library", ffi.RtldNoLoad)
+	if err == nil {
+		t.Errorf("expected an error loading a missing library with RtldNoLoad")
+	}
+}
+
+func TestIsLoaded(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	if !ffi.IsLoaded(libm_name) {
+		t.Errorf("expected [%s] to be loaded", libm_name)
+	}
+	if !ffi.IsLoaded("m") {
+		t.Errorf("expected [m] to be loaded")
+	}
+	if ffi.IsLoaded("no-such-ffi-library") {
+		t.Errorf("expected [no-such-ffi-library] not to be loaded")
+	}
+}
+
+// EOF
@@ -1,17 +1,24 @@
 package ffi
 
+// #include <errno.h>
 // #include <stdlib.h>
 // #include "ffi.h"
 // typedef void (*_go_ffi_fctptr_t)(void);
+// static int _go_ffi_call_errno(ffi_cif *cif, _go_ffi_fctptr_t fn, void *rvalue, void **avalue)
+// {
+//   errno = 0;
+//   ffi_call(cif, fn, rvalue, avalue);
+//   return errno;
+// }
 import "C"
 
 import (
 	"fmt"
 	"reflect"
+	"runtime"
 	"strings"
+	"syscall"
 	"unsafe"
-
-	"github.com/gonuts/dl"
 )
 
 // Abi is the ffi abi of the local plateform
@@ -23,6 +30,12 @@ const (
 	LastAbi    Abi = C.FFI_LAST_ABI
 )
 
+// IsValid returns whether abi is a valid abi for the local platform.
+// The named abis of a platform are declared in ffi_$GOARCH.go
+func (abi Abi) IsValid() bool {
+	return abi > FirstAbi && abi < LastAbi
+}
+
 const (
 	TrampolineSize = C.FFI_TRAMPOLINE_SIZE
 	NativeRawApi   = C.FFI_NATIVE_RAW_API
@@ -70,117 +83,208 @@ type Cif struct {
 	args  []Type
 }
 
+// FctPtr is a C function pointer
 type FctPtr struct {
-	c C._go_ffi_fctptr_t
+	c   C._go_ffi_fctptr_t
+	lib *lib_ref // keeps the library loaded, see Library.FctPtr
+}
+
+// NewFctPtr returns a FctPtr from the address of a C function.
+// It returns an error if ptr is nil.
+func NewFctPtr(ptr unsafe.Pointer) (FctPtr, error) {
+	if ptr == nil {
+		return FctPtr{}, fmt.Errorf("ffi.NewFctPtr: nil function pointer")
+	}
+	return FctPtr{(C._go_ffi_fctptr_t)(ptr), nil}, nil
+}
+
+// FctPtrFromValue returns the FctPtr held by v, a Value of pointer kind
+// (e.g. the field of a C struct holding a function pointer).
+// It returns an error if v is not a pointer or is a nil pointer.
+func FctPtrFromValue(v Value) (FctPtr, error) {
+	if !v.IsValid() || v.Kind() != Ptr {
+		return FctPtr{}, fmt.Errorf("ffi.FctPtrFromValue: expected a Value of kind Ptr")
+	}
+	return NewFctPtr(*(*unsafe.Pointer)(v.val))
+}
+
+// IsNil returns whether fct is a nil function pointer.
+func (fct FctPtr) IsNil() bool {
+	return fct.c == nil
+}
+
+// Pointer returns the address of the C function.
+func (fct FctPtr) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(fct.c)
 }
 
 // NewCif creates a new ffi call interface object
 func NewCif(abi Abi, rtype Type, args []Type) (*Cif, error) {
+	return new_cif(abi, -1, rtype, args)
+}
+
+// NewCifVar creates a new ffi call interface object for a variadic function.
+// nfixed is the number of fixed arguments, args holds the types of both the
+// fixed and the variadic arguments.
+func NewCifVar(abi Abi, nfixed int, rtype Type, args []Type) (*Cif, error) {
+	if nfixed < 0 || nfixed > len(args) {
+		return nil, fmt.Errorf("ffi.NewCifVar: invalid number of fixed arguments (%d, with %d arguments)", nfixed, len(args))
+	}
+	return new_cif(abi, nfixed, rtype, args)
+}
+
+// new_cif prepares a cif. nfixed is negative for non-variadic functions.
+func new_cif(abi Abi, nfixed int, rtype Type, args []Type) (*Cif, error) {
+	if !abi.IsValid() {
+		return nil, fmt.Errorf("ffi: invalid abi %d (valid abis are in ]%d, %d[)",
+			int(abi), int(FirstAbi), int(LastAbi))
+	}
 	cif := &Cif{}
 	c_nargs := C.uint(len(args))
-	var c_args **C.ffi_type = nil
-	if len(args) > 0 {
-		var cargs = make([]*C.ffi_type, len(args))
-		for i, _ := range args {
-			cargs[i] = args[i].cptr()
-		}
-		c_args = &cargs[0]
+	c_args := c_arg_types(args)
+	var sc C.ffi_status
+	if nfixed < 0 {
+		sc = C.ffi_prep_cif(&cif.c, C.ffi_abi(abi), c_nargs, rtype.cptr(), c_args)
+	} else {
+		sc = C.ffi_prep_cif_var(&cif.c, C.ffi_abi(abi), C.uint(nfixed), c_nargs, rtype.cptr(), c_args)
 	}
-	sc := C.ffi_prep_cif(&cif.c, C.ffi_abi(abi), c_nargs, rtype.cptr(), c_args)
 	if sc != C.FFI_OK {
+		C.free(unsafe.Pointer(c_args))
 		return nil, fmt.Errorf("error while preparing cif (%s)",
 			Status(sc))
 	}
+	if c_args != nil {
+		runtime.SetFinalizer(cif, func(cif *Cif) {
+			C.free(unsafe.Pointer(cif.c.arg_types))
+		})
+	}
 	cif.rtype = rtype
 	cif.args = args
 	return cif, nil
 }
 
-// Call invokes the cif with the provided function pointer and arguments
+// c_arg_types returns the ffi types of args in an array allocated by
+// C.malloc, as libffi keeps referencing it, or nil if args is empty.
+func c_arg_types(args []Type) **C.ffi_type {
+	if len(args) == 0 {
+		return nil
+	}
+	c_args := (**C.ffi_type)(C.malloc(C.size_t(uintptr(len(args)) * ptrSize)))
+	cargs := (*[1 << 20]*C.ffi_type)(unsafe.Pointer(c_args))[:len(args):len(args)]
+	for i, t := range args {
+		cargs[i] = t.cptr()
+	}
+	return c_args
+}
+
+// Call invokes the cif with the provided function pointer and arguments.
+// Arguments are converted to the types the cif was prepared with, and an
+// error is returned if that conversion would lose information.
+// Structs are passed by value as ffi.Value (or compatible go struct)
+// arguments, pointers as strings, go pointers and slices, unsafe.Pointer,
+// uintptr, FctPtr, *Callback, OutParam or nil.
+// Struct results are returned as a reflect.Value holding a new ffi.Value.
 func (cif *Cif) Call(fct FctPtr, args ...interface{}) (reflect.Value, error) {
+	out, _, err := cif.call(fct, call_plain, args)
+	return out, err
+}
+
+// CallErrno invokes the cif like Call does, and returns the value of errno
+// right after the call. errno is cleared before the call.
+// errno is read on the same OS thread than the call.
+func (cif *Cif) CallErrno(fct FctPtr, args ...interface{}) (reflect.Value, syscall.Errno, error) {
+	return cif.call(fct, call_errno, args)
+}
+
+// call_mode tells how ffi_call is invoked
+type call_mode int
+
+const (
+	call_plain   call_mode = iota
+	call_errno             // capture errno
+	call_guarded           // recover from faults
+)
+
+func (cif *Cif) call(fct FctPtr, mode call_mode, args []interface{}) (reflect.Value, syscall.Errno, error) {
 	nargs := len(args)
 	if nargs != int(cif.c.nargs) {
-		return reflect.New(reflect.TypeOf(0)), fmt.Errorf("ffi: invalid number of arguments. expected '%d', got '%s'.",
-			int(cif.c.nargs), nargs)
-	}
-	var c_args *unsafe.Pointer = nil
-	if nargs > 0 {
-		cargs := make([]unsafe.Pointer, nargs)
-		for i, _ := range args {
-			var carg unsafe.Pointer
-			//fmt.Printf("[%d]: (%v)\n", i, args[i])
-			t := reflect.TypeOf(args[i])
-			rv := reflect.ValueOf(args[i])
-			switch t.Kind() {
-			case reflect.String:
-				cstr := C.CString(args[i].(string))
-				defer C.free(unsafe.Pointer(cstr))
-				carg = unsafe.Pointer(&cstr)
-			case reflect.Ptr:
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Float32:
-				vv := args[i].(float32)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Float64:
-				vv := args[i].(float64)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Int:
-				vv := args[i].(int)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Int8:
-				vv := args[i].(int8)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Int16:
-				vv := args[i].(int16)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Int32:
-				vv := args[i].(int32)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Int64:
-				vv := args[i].(int64)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Uint:
-				vv := args[i].(uint)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Uint8:
-				vv := args[i].(uint8)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Uint16:
-				vv := args[i].(uint16)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Uint32:
-				vv := args[i].(uint32)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			case reflect.Uint64:
-				vv := args[i].(uint64)
-				rv = reflect.ValueOf(&vv)
-				carg = unsafe.Pointer(rv.Elem().UnsafeAddr())
-			}
-			cargs[i] = carg
+		return reflect.New(reflect.TypeOf(0)), 0, &CallError{-1, fmt.Sprintf(
+			"invalid number of arguments. expected '%d', got '%d'.",
+			int(cif.c.nargs), nargs)}
+	}
+	frame := new_call_frame(nargs)
+	defer frame.free()
+	for i := range args {
+		err := frame.set(i, cif.args[i], args[i])
+		if err != nil {
+			return reflect.Value{}, 0, err
 		}
-		c_args = &cargs[0]
 	}
-	out := reflect.New(rtype_from_ffi(cif.rtype.cptr()))
-	var c_out unsafe.Pointer = unsafe.Pointer(out.Elem().UnsafeAddr())
+	c_args := frame.c_args()
+
+	var errno syscall.Errno
+	c_out := result_buffer(cif.rtype)
 	//println("...ffi_call...")
-	C.ffi_call(&cif.c, fct.c, c_out, c_args)
-	//fmt.Printf("...ffi_call...[done] [%v]\n",out.Elem())
-	return out.Elem(), nil
+	switch mode {
+	case call_errno:
+		errno = syscall.Errno(C._go_ffi_call_errno(&cif.c, fct.c, c_out, c_args))
+	case call_guarded:
+		err := ffi_call_guarded(cif, fct, c_out, c_args)
+		if err != nil {
+			runtime.KeepAlive(args)
+			return reflect.Value{}, 0, err
+		}
+	default:
+		C.ffi_call(&cif.c, fct.c, c_out, c_args)
+	}
+	// go values referenced by the C arguments must survive the call
+	runtime.KeepAlive(args)
+
+	out := goresult(cif.rtype, c_out)
+
+	for _, o := range frame.outs {
+		err := o.p.decode(o.cval)
+		if err != nil {
+			return out, errno, &CallError{o.arg, err.Error()}
+		}
+	}
+	return out, errno, nil
+}
+
+// A CallError describes a call through a Cif which could not be performed.
+type CallError struct {
+	Arg int    // index of the offending argument, -1 if none
+	Msg string // description of the error
+}
+
+func (e *CallError) Error() string {
+	if e.Arg < 0 {
+		return "ffi: " + e.Msg
+	}
+	return fmt.Sprintf("ffi: argument #%d: %s", e.Arg, e.Msg)
+}
+
+func value_type_name(v Value) string {
+	if v.Type() == nil {
+		return "<nil>"
+	}
+	return v.Type().Name()
 }
 
 type go_void struct{}
 
+// rtype_from_type returns the go type holding C values of type t, as
+// returned by Cif.Call.
+func rtype_from_type(t Type) reflect.Type {
+	switch t.Kind() {
+	case Struct, Slice:
+		return g_value_type
+	case Ptr, Array:
+		return reflect.TypeOf(uintptr(0))
+	}
+	return rtype_from_ffi(t.cptr())
+}
+
 func rtype_from_ffi(t *C.ffi_type) reflect.Type {
 	switch t {
 	case &C.ffi_type_void:
@@ -232,9 +336,21 @@ type Closure struct {
 	c C.ffi_closure
 }
 
-// Library is a dl-opened library holding the corresponding dl.Handle
+// Library is a dl-opened library holding the corresponding dlopen handle.
+// Copies of a Library (see WithPolicy) and its functions share the lifetime
+// of the library, see Close.
 type Library struct {
-	handle dl.Handle
+	handle  dl_handle
+	state   *lib_state  // lifetime of the library
+	path    string      // file of the dl-opened library
+	pseudo  pseudo_kind // special dlsym handle used instead of handle, see Self
+	backend backend     // provides the functions instead of handle, if not nil
+	policy  Policy      // concurrency policy of the functions of the library
+
+	names    NamePolicy      // alternative names of the functions, see WithNames
+	optional map[string]bool // optional functions, see Optional
+
+	interceptors []Interceptor // interceptors of the calls, see Use
 }
 
 func get_lib_arch_name(libname string) string {
@@ -249,17 +365,36 @@ func get_lib_arch_name(libname string) string {
 }
 
 // NewLibrary takes the library filename and returns a handle towards it.
-func NewLibrary(libname string) (lib Library, err error) {
-	//libname = get_lib_arch_name(libname)
-	lib.handle, err = dl.Open(libname, dl.Now)
-	return
+//
+// libname may be a path, a library file name ("libm.so.6") or a short name
+// ("m"): NewLibrary then searches the directories of $FFI_LIBRARY_PATH and
+// of the dynamic linker's search path variable, the dynamic linker's cache
+// and pkg-config, preferring the highest versions.
+// The returned error is a *LibraryError, listing the candidates tried.
+func NewLibrary(libname string) (*Library, error) {
+	return NewLibraryWithFlags(libname, RtldNow)
+}
+
+// backend provides the functions of a Library which is not dl-opened.
+type backend interface {
+	// function returns the function fctname, of the given signature.
+	// The types of the variadic arguments, if any, are not part of argtypes.
+	function(fctname string, rtype Type, argtypes []Type, variadic bool) (ErrnoFunction, error)
+	// has returns whether the backend provides the function fctname.
+	has(fctname string) bool
+	close() error
 }
 
-func (lib Library) Close() error {
-	return lib.handle.Close()
+// WithPolicy returns a copy of lib whose functions are called according to
+// the concurrency policy p.
+func (lib Library) WithPolicy(p Policy) *Library {
+	lib.policy = p
+	return &lib
 }
 
-// Function is a dl-loaded function from a dl-opened library
+// Function is a dl-loaded function from a dl-opened library.
+// Calling a Function panics if the call could not be performed, see
+// Function.Call for a non-panicking alternative.
 type Function func(args ...interface{}) reflect.Value
 
 type cfct struct {
@@ -267,7 +402,57 @@ type cfct struct {
 }
 
 var nil_fct Function = func(args ...interface{}) reflect.Value {
-	panic("ffi: nil_fct called")
+	panic(fmt.Errorf("ffi: nil_fct called"))
+}
+
+// Call invokes the function with the provided arguments.
+// Contrary to calling fct directly, errors are reported as values instead of
+// panics.
+func (fct Function) Call(args ...interface{}) (out reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = call_error_from(r)
+		}
+	}()
+	return fct(args...), nil
+}
+
+// ErrnoFunction is a dl-loaded function returning, along with its result,
+// the value of errno right after the call.
+// Calling an ErrnoFunction panics if the call could not be performed.
+type ErrnoFunction func(args ...interface{}) (reflect.Value, syscall.Errno)
+
+// Call invokes the function with the provided arguments, reporting errors
+// as values instead of panics.
+func (fct ErrnoFunction) Call(args ...interface{}) (out reflect.Value, errno syscall.Errno, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = call_error_from(r)
+		}
+	}()
+	out, errno = fct(args...)
+	return out, errno, nil
+}
+
+// function returns a Function discarding the errno reported by fct.
+func (fct ErrnoFunction) function() Function {
+	return func(args ...interface{}) reflect.Value {
+		out, _ := fct(args...)
+		return out
+	}
+}
+
+// call_error_from returns the error a Function panicked with.
+// Panics not originating from a failed call are propagated.
+func call_error_from(r interface{}) error {
+	err, ok := r.(error)
+	if !ok {
+		panic(r)
+	}
+	if _, ok := err.(runtime.Error); ok {
+		panic(r)
+	}
+	return err
 }
 
 /*
@@ -297,29 +482,318 @@ func (lib Library) Fct(fctname string) (Function, error) {
 }
 */
 
+// Fct returns a Function calling fctname, with the default abi of the
+// platform.
 func (lib Library) Fct(fctname string, rtype Type, argtypes []Type) (Function, error) {
+	return lib.FctAbi(fctname, DefaultAbi, rtype, argtypes)
+}
+
+// FctAbi returns a Function calling fctname, following the calling
+// convention abi.
+func (lib Library) FctAbi(fctname string, abi Abi, rtype Type, argtypes []Type) (Function, error) {
 	//println("Fct(",fctname,")...")
-	sym, err := lib.handle.Symbol(fctname)
+	if lib.backend != nil {
+		fn, name, err := lib.backend_function(fctname, rtype, argtypes, false)
+		if err != nil {
+			return lib.unavailable(fctname, err)
+		}
+		return lib.wrap(fn.function(), name, rtype, argtypes), nil
+	}
+	fct, name, err := lib.lookup(fctname)
+	if err != nil {
+		return lib.unavailable(fctname, err)
+	}
+	fn, err := make_function(fct, abi, rtype, argtypes)
 	if err != nil {
 		return nil_fct, err
 	}
+	return lib.wrap(fn, name, rtype, argtypes), nil
+}
 
-	addr := (C._go_ffi_fctptr_t)(unsafe.Pointer(sym))
-	cif, err := NewCif(DefaultAbi, rtype, argtypes)
+// wrap applies the interceptors and the concurrency policy of lib to fct.
+func (lib Library) wrap(fct Function, fctname string, rtype Type, argtypes []Type) Function {
+	info := CallInfo{Symbol: fctname, RType: rtype, ArgTypes: argtypes}
+	return fct.guard(lib.state).intercept(info, lib.interceptors).WithPolicy(lib.policy)
+}
+
+// wrap_errno applies the interceptors and the concurrency policy of lib to
+// fct.
+func (lib Library) wrap_errno(fct ErrnoFunction, fctname string, rtype Type, argtypes []Type) ErrnoFunction {
+	info := CallInfo{Symbol: fctname, RType: rtype, ArgTypes: argtypes}
+	return fct.guard(lib.state).intercept(info, lib.interceptors).WithPolicy(lib.policy)
+}
+
+// FctPtr returns the address of the function fctname, e.g. to be called
+// through a Cif.
+// The library is not unloaded while the FctPtr is reachable.
+func (lib Library) FctPtr(fctname string) (FctPtr, error) {
+	if lib.backend != nil {
+		return FctPtr{}, fmt.Errorf("ffi: no function pointer for [%s] in a library which is not dl-opened", fctname)
+	}
+	fct, _, err := lib.lookup(fctname)
+	if err != nil {
+		return FctPtr{}, err
+	}
+	fct.lib = lib.state.ref()
+	return fct, nil
+}
+
+// Var returns the exported global variable name of the library, as a Value
+// of type typ aliasing the variable: reading or setting the Value reads or
+// modifies the variable.
+// The library is not unloaded while the Value, or a Value of one of its
+// fields or elements, is reachable.
+func (lib Library) Var(name string, typ Type) (Value, error) {
+	if typ == nil {
+		return Value{}, fmt.Errorf("ffi: nil type for variable [%s]", name)
+	}
+	if lib.backend != nil {
+		return Value{}, fmt.Errorf("ffi: no variable [%s] in a library which is not dl-opened", name)
+	}
+	sym, err := lib.symbol(name)
+	if err != nil {
+		return Value{}, err
+	}
+	if sym == nil {
+		return Value{}, fmt.Errorf("ffi: nil address for variable [%s]", name)
+	}
+	return Value{typ, sym, lib.state.ref()}, nil
+}
+
+// MakeFunction returns a Function calling the C function pointer fct, with
+// the default abi of the platform.
+func MakeFunction(fct FctPtr, rtype Type, argtypes []Type) (Function, error) {
+	return make_function(fct, DefaultAbi, rtype, argtypes)
+}
+
+func make_function(fct FctPtr, abi Abi, rtype Type, argtypes []Type) (Function, error) {
+	if fct.IsNil() {
+		return nil_fct, fmt.Errorf("ffi: nil function pointer")
+	}
+	cif, err := NewCif(abi, rtype, argtypes)
 	if err != nil {
 		return nil_fct, err
 	}
 
-	fct := func(args ...interface{}) reflect.Value {
+	fn := func(args ...interface{}) reflect.Value {
 		//println("...call.cif...")
-		out, err := cif.Call(FctPtr{addr}, args...)
+		out, err := cif.Call(fct, args...)
 		if err != nil {
 			panic(err)
 		}
 		//println("...call.cif...[done]")
 		return out
 	}
-	return Function(fct), nil
+	return Function(fn), nil
+}
+
+// FctErrno returns an ErrnoFunction calling fctname, reporting the value of
+// errno right after each call.
+func (lib Library) FctErrno(fctname string, rtype Type, argtypes []Type) (ErrnoFunction, error) {
+	if lib.backend != nil {
+		fn, name, err := lib.backend_function(fctname, rtype, argtypes, false)
+		if err != nil {
+			return lib.unavailable_errno(fctname, err)
+		}
+		return lib.wrap_errno(fn, name, rtype, argtypes), nil
+	}
+	addr, name, err := lib.lookup(fctname)
+	if err != nil {
+		return lib.unavailable_errno(fctname, err)
+	}
+
+	cif, err := NewCif(DefaultAbi, rtype, argtypes)
+	if err != nil {
+		return nil, err
+	}
+
+	fct := func(args ...interface{}) (reflect.Value, syscall.Errno) {
+		out, errno, err := cif.CallErrno(addr, args...)
+		if err != nil {
+			panic(err)
+		}
+		return out, errno
+	}
+	return lib.wrap_errno(ErrnoFunction(fct), name, rtype, argtypes), nil
+}
+
+// FctVariadic returns a Function calling the variadic function fctname,
+// whose fixed arguments are described by argtypes.
+// The types of the variadic arguments are inferred at each call from the go
+// values passed to the Function, after the C default argument promotions.
+func (lib Library) FctVariadic(fctname string, rtype Type, argtypes []Type) (Function, error) {
+	if lib.backend != nil {
+		fn, name, err := lib.backend_function(fctname, rtype, argtypes, true)
+		if err != nil {
+			return lib.unavailable(fctname, err)
+		}
+		return lib.wrap(fn.function(), name, rtype, argtypes), nil
+	}
+	addr, name, err := lib.lookup(fctname)
+	if err != nil {
+		return lib.unavailable(fctname, err)
+	}
+
+	nfixed := len(argtypes)
+
+	fct := func(args ...interface{}) reflect.Value {
+		if len(args) < nfixed {
+			panic(&CallError{-1, fmt.Sprintf(
+				"invalid number of arguments. expected at least '%d', got '%d'.",
+				nfixed, len(args))})
+		}
+		types := make([]Type, len(args))
+		copy(types, argtypes)
+		vargs := make([]interface{}, len(args))
+		copy(vargs, args)
+		for i := nfixed; i < len(args); i++ {
+			typ, arg, err := ctype_from_vararg(i, args[i])
+			if err != nil {
+				panic(err)
+			}
+			types[i], vargs[i] = typ, arg
+		}
+		cif, err := NewCifVar(DefaultAbi, nfixed, rtype, types)
+		if err != nil {
+			panic(err)
+		}
+		out, err := cif.Call(addr, vargs...)
+		if err != nil {
+			panic(err)
+		}
+		return out
+	}
+	return lib.wrap(Function(fct), name, rtype, argtypes), nil
+}
+
+// ctype_from_vararg returns the ffi type of the i-th argument of a variadic
+// call, together with the argument converted following the C default
+// argument promotions.
+func ctype_from_vararg(i int, arg interface{}) (Type, interface{}, error) {
+	rv := reflect.ValueOf(arg)
+	if !rv.IsValid() {
+		return nil, nil, &CallError{i, "invalid nil variadic argument"}
+	}
+	switch rv.Kind() {
+	case reflect.String, reflect.Ptr, reflect.Uintptr, reflect.UnsafePointer:
+		return C_pointer, arg, nil
+	case reflect.Struct:
+		if _, ok := arg.(OutParam); ok {
+			return C_pointer, arg, nil
+		}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return C_int32, int32(rv.Int()), nil
+	case reflect.Uint8, reflect.Uint16:
+		return C_int32, int32(rv.Uint()), nil
+	case reflect.Uint32:
+		return C_uint32, uint32(rv.Uint()), nil
+	case reflect.Int:
+		// a go int is a C int or a C long, depending on the platform
+		if unsafe.Sizeof(int(0)) == 4 {
+			return C_int, int32(rv.Int()), nil
+		}
+		return C_long, rv.Int(), nil
+	case reflect.Uint:
+		if unsafe.Sizeof(uint(0)) == 4 {
+			return C_uint, uint32(rv.Uint()), nil
+		}
+		return C_ulong, rv.Uint(), nil
+	case reflect.Int64:
+		return C_int64, rv.Int(), nil
+	case reflect.Uint64:
+		return C_uint64, rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return C_double, rv.Float(), nil
+	}
+	return nil, nil, &CallError{i, fmt.Sprintf("unsupported variadic argument type %T", arg)}
+}
+
+// Bind resolves the function fctname and stores into fptr, a pointer to a
+// func variable, a Go function calling it.
+// The C signature is derived from the type of the func variable.
+func (lib Library) Bind(fptr interface{}, fctname string) error {
+	rv := reflect.ValueOf(fptr)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("ffi.Bind: expected a pointer to a func (got %T)", fptr)
+	}
+	ft := rv.Elem().Type()
+	rtype, argtypes, err := ctypes_from_gofunc(ft)
+	if err != nil {
+		return err
+	}
+	fct, err := lib.Fct(fctname, rtype, argtypes)
+	if err != nil {
+		return err
+	}
+	wrapper := func(in []reflect.Value) []reflect.Value {
+		args := make([]interface{}, len(in))
+		for i := range in {
+			args[i] = in[i].Interface()
+		}
+		out := fct(args...)
+		if ft.NumOut() == 0 {
+			return nil
+		}
+		return []reflect.Value{goresult_from_ffi(out, ft.Out(0))}
+	}
+	rv.Elem().Set(reflect.MakeFunc(ft, wrapper))
+	return nil
+}
+
+// ctypes_from_gofunc returns the return and argument types of a C function
+// with the same signature than the go func type ft.
+func ctypes_from_gofunc(ft reflect.Type) (rtype Type, args []Type, err error) {
+	if ft.IsVariadic() {
+		return nil, nil, fmt.Errorf("ffi.Bind: variadic func types are not supported (%s)", ft)
+	}
+	if ft.NumOut() > 1 {
+		return nil, nil, fmt.Errorf("ffi.Bind: func types with more than one result are not supported (%s)", ft)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ffi.Bind: unsupported func type %s (%v)", ft, r)
+		}
+	}()
+	ctype := func(rt reflect.Type) Type {
+		switch rt.Kind() {
+		case reflect.String, reflect.Uintptr, reflect.UnsafePointer:
+			return C_pointer
+		}
+		return ctype_from_gotype(rt)
+	}
+	rtype = C_void
+	if ft.NumOut() == 1 {
+		rtype = ctype(ft.Out(0))
+	}
+	args = make([]Type, ft.NumIn())
+	for i := range args {
+		args[i] = ctype(ft.In(i))
+	}
+	return rtype, args, nil
+}
+
+// goresult_from_ffi converts the value returned by Cif.Call into a value of
+// the go type rt.
+func goresult_from_ffi(out reflect.Value, rt reflect.Type) reflect.Value {
+	switch rt.Kind() {
+	case reflect.String:
+		cstr := (*C.char)(ptr_result(out))
+		return reflect.ValueOf(C.GoString(cstr)).Convert(rt)
+	case reflect.UnsafePointer:
+		return reflect.ValueOf(ptr_result(out)).Convert(rt)
+	}
+	return out.Convert(rt)
+}
+
+// ptr_result returns the C pointer held by out, a pointer result returned
+// by Cif.Call as a uintptr.
+func ptr_result(out reflect.Value) unsafe.Pointer {
+	if !out.CanAddr() {
+		v := reflect.New(out.Type()).Elem()
+		v.Set(out)
+		out = v
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(out.UnsafeAddr()))
 }
 
 // EOF
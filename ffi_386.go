@@ -0,0 +1,17 @@
+package ffi
+
+// #include "ffi.h"
+import "C"
+
+// abis supported on x86 platforms
+const (
+	SysvAbi     Abi = C.FFI_SYSV
+	StdcallAbi  Abi = C.FFI_STDCALL
+	ThiscallAbi Abi = C.FFI_THISCALL
+	FastcallAbi Abi = C.FFI_FASTCALL
+	MsCdeclAbi  Abi = C.FFI_MS_CDECL
+	PascalAbi   Abi = C.FFI_PASCAL
+	RegisterAbi Abi = C.FFI_REGISTER
+)
+
+// EOF
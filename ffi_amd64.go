@@ -0,0 +1,14 @@
+package ffi
+
+// #include "ffi.h"
+import "C"
+
+// abis supported on x86-64 (unix) platforms
+const (
+	Unix64Abi Abi = C.FFI_UNIX64
+	Win64Abi  Abi = C.FFI_WIN64 // ms_abi, sizeof(long double) == 8
+	Efi64Abi  Abi = C.FFI_EFI64
+	GnuW64Abi Abi = C.FFI_GNUW64 // ms_abi, sizeof(long double) == 16
+)
+
+// EOF
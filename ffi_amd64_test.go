@@ -0,0 +1,22 @@
+package ffi_test
+
+import (
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestAmd64Abis(t *testing.T) {
+	eq(t, ffi.Unix64Abi, ffi.DefaultAbi)
+	for _, abi := range []ffi.Abi{ffi.Unix64Abi, ffi.Win64Abi, ffi.Efi64Abi, ffi.GnuW64Abi} {
+		if !abi.IsValid() {
+			t.Errorf("abi %d should be valid", abi)
+		}
+		_, err := ffi.NewCif(abi, ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_int32})
+		if err != nil {
+			t.Errorf("could not create cif with abi %d: %v", abi, err)
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,11 @@
+package ffi
+
+// #include "ffi.h"
+import "C"
+
+// abis supported on arm64 platforms
+const (
+	SysvAbi Abi = C.FFI_SYSV
+)
+
+// EOF
@@ -2,5 +2,6 @@ package ffi_test
 
 var libc_name = "libc.dylib"
 var libm_name = "libm.dylib"
+var libbz2_name = "libbz2.dylib"
 
 // EOF
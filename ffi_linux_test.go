@@ -2,5 +2,6 @@ package ffi_test
 
 var libc_name = "libc.so.6"
 var libm_name = "libm.so"
+var libbz2_name = "libbz2.so.1.0"
 
 // EOF
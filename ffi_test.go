@@ -1,10 +1,14 @@
 package ffi_test
 
 import (
+	"fmt"
 	"math"
+	"os"
 	"path"
 	"reflect"
 	"runtime"
+	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/gonuts/ffi"
@@ -181,4 +185,404 @@ func TestFFIStrCat(t *testing.T) {
 	}
 }
 
+func TestFFIBind(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	var cos func(float64) float64
+	err = lib.Bind(&cos, "cos")
+	if err != nil {
+		t.Fatalf("could not bind function [cos]: %v", err)
+	}
+	eq(t, math.Cos(0.), cos(0.))
+	eq(t, math.Cos(math.Pi), cos(math.Pi))
+
+	var abs func(int32) int32
+	err = lib.Bind(&abs, "abs")
+	if err != nil {
+		t.Fatalf("could not bind function [abs]: %v", err)
+	}
+	eq(t, int32(10), abs(-10))
+
+	err = lib.Bind(cos, "cos")
+	if err == nil {
+		t.Errorf("expected an error binding a non-pointer")
+	}
+
+	var bad func(float64) (float64, error)
+	err = lib.Bind(&bad, "cos")
+	if err == nil {
+		t.Errorf("expected an error binding a func with 2 results")
+	}
+}
+
+func TestFFIBindString(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	var strlen func(string) uint64
+	err = lib.Bind(&strlen, "strlen")
+	if err != nil {
+		t.Fatalf("could not bind function [strlen]: %v", err)
+	}
+	eq(t, uint64(7), strlen("foo-bar"))
+
+	var getenv func(string) string
+	err = lib.Bind(&getenv, "getenv")
+	if err != nil {
+		t.Fatalf("could not bind function [getenv]: %v", err)
+	}
+	os.Setenv("GO_FFI_TEST_BIND", "ok")
+	eq(t, "ok", getenv("GO_FFI_TEST_BIND"))
+}
+
+func TestFFIVariadic(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	malloc, err := lib.Fct("malloc", ffi.C_pointer, []ffi.Type{ffi.C_uint64})
+	if err != nil {
+		t.Fatalf("could not locate function [malloc]: %v", err)
+	}
+	free, err := lib.Fct("free", ffi.C_void, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [free]: %v", err)
+	}
+	var strdup func(uintptr) string
+	err = lib.Bind(&strdup, "strdup")
+	if err != nil {
+		t.Fatalf("could not bind function [strdup]: %v", err)
+	}
+
+	//int snprintf(char *str, size_t size, const char *format, ...);
+	snprintf, err := lib.FctVariadic("snprintf", ffi.C_int32,
+		[]ffi.Type{ffi.C_pointer, ffi.C_uint64, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [snprintf]: %v", err)
+	}
+
+	const sz = 64
+	buf := uintptr(malloc(uint64(sz)).Uint())
+	defer free(buf)
+
+	for _, table := range []struct {
+		format string
+		args   []interface{}
+		res    string
+	}{
+		{"no-args", nil, "no-args"},
+		{"%d-%s", []interface{}{int32(42), "foo"}, "42-foo"},
+		{"%.2f|%.1f", []interface{}{3.5, float32(-1.5)}, "3.50|-1.5"},
+		{"%d %u %c", []interface{}{int8(-2), uint32(7), uint8('x')}, "-2 7 x"},
+		{"%ld|%d|%lu", []interface{}{int(-3), int32(-4), uint(5)}, "-3|-4|5"},
+	} {
+		args := append([]interface{}{buf, uint64(sz), table.format}, table.args...)
+		n := snprintf(args...).Int()
+		eq(t, int64(len(table.res)), n)
+		eq(t, table.res, strdup(buf))
+	}
+}
+
+func TestFFIVariadicConcurrent(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	snprintf, err := lib.FctVariadic("snprintf", ffi.C_int32,
+		[]ffi.Type{ffi.C_pointer, ffi.C_uint64, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [snprintf]: %v", err)
+	}
+
+	// the calls of a variadic Function do not share their state, even when
+	// running in parallel on a single cpu
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				buf := make([]byte, 64)
+				n, err := snprintf.Call(buf, uint64(len(buf)), "%d-%.1f%d%d%d%d%d%d%d%d",
+					int32(i), float64(j), 1, 2, 3, 4, 5, 6, 7, 8)
+				if err != nil {
+					t.Errorf("snprintf: %v", err)
+					return
+				}
+				res := fmt.Sprintf("%d-%.1f12345678", i, float64(j))
+				if string(buf[:n.Int()]) != res {
+					t.Errorf("expected [%s], got [%s]", res, buf[:n.Int()])
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestFFIStructByValue(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	// typedef struct { int quot; int rem; } div_t;
+	div_t, err := ffi.NewStructType("div_t", []ffi.Field{
+		{"quot", ffi.C_int32},
+		{"rem", ffi.C_int32},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	//div_t div(int numerator, int denominator);
+	div, err := lib.Fct("div", div_t, []ffi.Type{ffi.C_int32, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [div]: %v", err)
+	}
+	out := div(int32(17), int32(5)).Interface().(ffi.Value)
+	eq(t, div_t, out.Type())
+	eq(t, int64(3), out.Field(0).Int())
+	eq(t, int64(2), out.Field(1).Int())
+
+	// struct in_addr { uint32_t s_addr; };
+	in_addr, err := ffi.NewStructType("in_addr", []ffi.Field{
+		{"s_addr", ffi.C_uint32},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	//char *inet_ntoa(struct in_addr in);
+	inet_ntoa, err := lib.Fct("inet_ntoa", ffi.C_pointer, []ffi.Type{in_addr})
+	if err != nil {
+		t.Fatalf("could not locate function [inet_ntoa]: %v", err)
+	}
+	var strdup func(uintptr) string
+	err = lib.Bind(&strdup, "strdup")
+	if err != nil {
+		t.Fatalf("could not bind function [strdup]: %v", err)
+	}
+
+	addr := ffi.New(in_addr)
+	addr.Field(0).SetUint(0x0100007f) // 127.0.0.1, in network byte order
+	eq(t, "127.0.0.1", strdup(uintptr(inet_ntoa(addr).Uint())))
+
+	// a mismatched aggregate is reported as an error, not a panic
+	in_addr_pair, err := ffi.NewStructType("in_addr_pair", []ffi.Field{
+		{"s_addr", ffi.C_uint32},
+		{"d_addr", ffi.C_uint32},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, err = inet_ntoa.Call(ffi.New(in_addr_pair))
+	if _, ok := err.(*ffi.CallError); !ok {
+		t.Errorf("expected a *ffi.CallError passing [in_addr_pair] as [in_addr] (got %v)", err)
+	}
+
+	// aggregates are only passed as Values of the same layout
+	quot, err := ffi.NewCallback(func(d ffi.Value) int32 {
+		return int32(d.Field(0).Int())
+	}, ffi.C_int32, []ffi.Type{div_t})
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer quot.Free()
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_int32, []ffi.Type{div_t})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	q, err := cif.Call(quot.FctPtr(), out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, int64(3), q.Int())
+	half_div_t, err := ffi.NewStructType("half_div_t", []ffi.Field{{"quot", ffi.C_int32}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, err = cif.Call(quot.FctPtr(), ffi.New(half_div_t))
+	if _, ok := err.(*ffi.CallError); !ok {
+		t.Errorf("expected a *ffi.CallError passing [half_div_t] as [div_t] (got %v)", err)
+	}
+}
+
+func TestFFICallErrors(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	cos, err := lib.Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [cos]: %v", err)
+	}
+
+	out, err := cos.Call(0.)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, 1.0, out.Float())
+
+	st, err := ffi.NewStructType("struct_call_errors", []ffi.Field{{"x", ffi.C_int32}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, table := range []struct {
+		args []interface{}
+		arg  int
+	}{
+		{nil, -1},
+		{[]interface{}{0., 1.}, -1},
+		{[]interface{}{map[int]int{}}, 0},
+		{[]interface{}{nil}, 0},
+		{[]interface{}{ffi.New(st)}, 0},
+	} {
+		_, err := cos.Call(table.args...)
+		if err == nil {
+			t.Errorf("expected an error calling cos%v", table.args)
+			continue
+		}
+		cerr, ok := err.(*ffi.CallError)
+		if !ok {
+			t.Errorf("expected a *ffi.CallError, got %T (%v)", err, err)
+			continue
+		}
+		eq(t, table.arg, cerr.Arg)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected a panic calling cos()")
+			}
+		}()
+		cos()
+	}()
+}
+
+func TestFFIErrno(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//int access(const char *pathname, int mode);
+	access, err := lib.FctErrno("access", ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [access]: %v", err)
+	}
+
+	out, errno := access("/non-existent/go-ffi", int32(0))
+	eq(t, int64(-1), out.Int())
+	eq(t, syscall.ENOENT, errno)
+
+	out, errno = access("/", int32(0))
+	eq(t, int64(0), out.Int())
+	eq(t, syscall.Errno(0), errno)
+
+	_, _, err = access.Call("/")
+	if err == nil {
+		t.Errorf("expected an error calling access with too few arguments")
+	}
+}
+
+func TestFFIAbi(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	cos, err := lib.FctAbi("cos", ffi.DefaultAbi, ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [cos]: %v", err)
+	}
+	eq(t, 1.0, cos(0.).Float())
+
+	for _, abi := range []ffi.Abi{ffi.FirstAbi, ffi.LastAbi, ffi.LastAbi + 10} {
+		if abi.IsValid() {
+			t.Errorf("abi %d should be invalid", abi)
+		}
+		_, err = lib.FctAbi("cos", abi, ffi.C_double, []ffi.Type{ffi.C_double})
+		if err == nil {
+			t.Errorf("expected an error with abi %d", abi)
+		}
+	}
+}
+
+func TestMakeFunction(t *testing.T) {
+	cb, err := ffi.NewCallback(
+		func(x, y float64) float64 { return x - y },
+		ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_double},
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cb.Free()
+
+	ptr, err := ffi.NewFctPtr(cb.Pointer())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	sub, err := ffi.MakeFunction(ptr, ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 1.5, sub(4., 2.5).Float())
+
+	// struct vtable { double (*sub)(double, double); };
+	vtable, err := ffi.NewStructType("vtable", []ffi.Field{{"sub", ffi.C_pointer}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	vt := ffi.New(vtable)
+	vt.Field(0).SetPointer(cb.Pointer())
+
+	ptr, err = ffi.FctPtrFromValue(vt.Field(0))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, cb.Pointer(), ptr.Pointer())
+	sub, err = ffi.MakeFunction(ptr, ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, -1.5, sub(1., 2.5).Float())
+
+	_, err = ffi.NewFctPtr(nil)
+	if err == nil {
+		t.Errorf("expected an error creating a nil FctPtr")
+	}
+	_, err = ffi.FctPtrFromValue(ffi.New(vtable).Field(0))
+	if err == nil {
+		t.Errorf("expected an error creating a FctPtr from a nil Value")
+	}
+	_, err = ffi.FctPtrFromValue(vt)
+	if err == nil {
+		t.Errorf("expected an error creating a FctPtr from a struct Value")
+	}
+	_, err = ffi.MakeFunction(ffi.FctPtr{}, ffi.C_void, nil)
+	if err == nil {
+		t.Errorf("expected an error making a Function from a nil FctPtr")
+	}
+}
+
 // EOF
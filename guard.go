@@ -0,0 +1,208 @@
+package ffi
+
+// #define _GNU_SOURCE
+// #include <pthread.h>
+// #include <setjmp.h>
+// #include <signal.h>
+// #include <string.h>
+// #ifdef __APPLE__
+// #include <sys/ucontext.h> // <ucontext.h> requires _XOPEN_SOURCE
+// #else
+// #include <ucontext.h>
+// #endif
+// #include "ffi.h"
+// typedef void (*_go_ffi_fctptr_t)(void);
+//
+// typedef struct {
+//   int signo;
+//   void *addr; // faulting address
+//   void *pc;   // faulting instruction, if known
+// } _go_ffi_fault_t;
+//
+// typedef struct {
+//   sigjmp_buf env;
+//   _go_ffi_fault_t fault;
+// } _go_ffi_guard_t;
+//
+// // the recovery point of the guarded call running on the thread, if any
+// static __thread _go_ffi_guard_t *_go_ffi_guard;
+//
+// static int _go_ffi_guard_signals[] = {SIGSEGV, SIGBUS, SIGFPE};
+// #define _GO_FFI_NGUARD_SIGNALS (sizeof(_go_ffi_guard_signals)/sizeof(_go_ffi_guard_signals[0]))
+// static struct sigaction _go_ffi_guard_prev[_GO_FFI_NGUARD_SIGNALS];
+// static struct sigaction _go_ffi_guard_sa;
+//
+// static void *_go_ffi_fault_pc(void *uctx)
+// {
+//   ucontext_t *uc = (ucontext_t*)uctx;
+// #if defined(__linux__) && defined(__x86_64__)
+//   return (void*)uc->uc_mcontext.gregs[REG_RIP];
+// #elif defined(__linux__) && defined(__i386__)
+//   return (void*)uc->uc_mcontext.gregs[REG_EIP];
+// #elif defined(__linux__) && defined(__aarch64__)
+//   return (void*)uc->uc_mcontext.pc;
+// #elif defined(__APPLE__) && defined(__x86_64__)
+//   return (void*)uc->uc_mcontext->__ss.__rip;
+// #elif defined(__APPLE__) && defined(__aarch64__)
+//   return (void*)uc->uc_mcontext->__ss.__pc;
+// #else
+//   (void)uc;
+//   return NULL;
+// #endif
+// }
+//
+// static void _go_ffi_guard_handler(int sig, siginfo_t *info, void *uctx)
+// {
+//   size_t i;
+//   _go_ffi_guard_t *g = _go_ffi_guard;
+//   if (g != NULL) {
+//     _go_ffi_guard = NULL;
+//     g->fault.signo = sig;
+//     g->fault.addr = info->si_addr;
+//     g->fault.pc = _go_ffi_fault_pc(uctx);
+//     siglongjmp(g->env, 1);
+//   }
+//   // not ours: chain to the previous handler (the go runtime's)
+//   for (i = 0; i < _GO_FFI_NGUARD_SIGNALS; i++) {
+//     struct sigaction *prev = &_go_ffi_guard_prev[i];
+//     if (_go_ffi_guard_signals[i] != sig) continue;
+//     if (prev->sa_flags & SA_SIGINFO) {
+//       prev->sa_sigaction(sig, info, uctx);
+//     } else if (prev->sa_handler == SIG_DFL) {
+//       // the default action terminates the process: raise the signal
+//       // with it, keeping the guard installed should raise return.
+//       sigset_t set;
+//       sigemptyset(&set);
+//       sigaddset(&set, sig);
+//       sigaction(sig, prev, NULL);
+//       pthread_sigmask(SIG_UNBLOCK, &set, NULL);
+//       raise(sig);
+//       sigaction(sig, &_go_ffi_guard_sa, NULL);
+//     } else if (prev->sa_handler != SIG_IGN) {
+//       prev->sa_handler(sig);
+//     }
+//     return;
+//   }
+// }
+//
+// static int _go_ffi_guard_install(void)
+// {
+//   size_t i;
+//   struct sigaction *sa = &_go_ffi_guard_sa;
+//   memset(sa, 0, sizeof(*sa));
+//   sa->sa_sigaction = _go_ffi_guard_handler;
+//   sa->sa_flags = SA_SIGINFO | SA_ONSTACK | SA_RESTART;
+//   sigemptyset(&sa->sa_mask);
+//   for (i = 0; i < _GO_FFI_NGUARD_SIGNALS; i++) {
+//     if (sigaction(_go_ffi_guard_signals[i], sa, &_go_ffi_guard_prev[i]) != 0) {
+//       return -1;
+//     }
+//   }
+//   return 0;
+// }
+//
+// static int _go_ffi_call_guarded(ffi_cif *cif, _go_ffi_fctptr_t fn, void *rvalue, void **avalue, _go_ffi_fault_t *fault)
+// {
+//   _go_ffi_guard_t g;
+//   _go_ffi_guard_t *prev = _go_ffi_guard;
+//   memset(&g.fault, 0, sizeof(g.fault));
+//   if (sigsetjmp(g.env, 1) != 0) {
+//     _go_ffi_guard = prev;
+//     *fault = g.fault;
+//     return 1;
+//   }
+//   _go_ffi_guard = &g;
+//   ffi_call(cif, fn, rvalue, avalue);
+//   _go_ffi_guard = prev;
+//   return 0;
+// }
+//
+// extern void _go_ffi_callback(ffi_cif *cif, void *ret, void **args, void *data);
+//
+// // _go_ffi_callback_entry runs a go callback with the guard of the thread
+// // suspended: faults in go code belong to the go runtime.
+// void _go_ffi_callback_entry(ffi_cif *cif, void *ret, void **args, void *data)
+// {
+//   _go_ffi_guard_t *g = _go_ffi_guard;
+//   _go_ffi_guard = NULL;
+//   _go_ffi_callback(cif, ret, args, data);
+//   _go_ffi_guard = g;
+// }
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// FaultError reports a synchronous fault (SIGSEGV, SIGBUS or SIGFPE) raised
+// by foreign code during a guarded call.
+type FaultError struct {
+	Signal syscall.Signal
+	Addr   uintptr // faulting memory address (or instruction, for SIGFPE)
+	PC     uintptr // faulting instruction, 0 if unknown
+	Symbol string  // symbol (or shared object) containing PC, see SymbolInfo.String
+}
+
+func (e *FaultError) Error() string {
+	msg := fmt.Sprintf("ffi: fault in foreign code: %v at address 0x%x", e.Signal, e.Addr)
+	if e.Symbol != "" {
+		msg += " in " + e.Symbol
+	}
+	return msg
+}
+
+var g_guard = struct {
+	once sync.Once
+	err  error
+}{}
+
+// CallGuarded invokes the cif like Call does, recovering from synchronous
+// faults raised by the C function: they are reported as a *FaultError
+// instead of crashing the go program.
+// The state of the foreign code after a fault is undefined (e.g. locks may
+// still be held, memory leaked): CallGuarded is meant to detect bad inputs,
+// not to keep on using a faulty library.
+//
+// The first guarded call installs process-wide handlers for SIGSEGV, SIGBUS
+// and SIGFPE, replacing those of the go runtime. They stay installed, and
+// pass the faults raised outside of guarded calls on to the handlers they
+// replaced: the go runtime still reports its own faults (e.g. nil pointer
+// dereferences as panics). Handlers installed afterwards for these signals
+// by other C code disable the guard.
+func (cif *Cif) CallGuarded(fct FctPtr, args ...interface{}) (reflect.Value, error) {
+	out, _, err := cif.call(fct, call_guarded, args)
+	return out, err
+}
+
+// ffi_call_guarded invokes ffi_call with a recovery point installed on the
+// calling thread.
+func ffi_call_guarded(cif *Cif, fct FctPtr, c_out unsafe.Pointer, c_args *unsafe.Pointer) error {
+	g_guard.once.Do(func() {
+		if C._go_ffi_guard_install() != 0 {
+			g_guard.err = fmt.Errorf("ffi: could not install the fault handlers")
+		}
+	})
+	if g_guard.err != nil {
+		return g_guard.err
+	}
+
+	var fault C._go_ffi_fault_t
+	if C._go_ffi_call_guarded(&cif.c, fct.c, c_out, c_args, &fault) == 0 {
+		return nil
+	}
+	err := &FaultError{
+		Signal: syscall.Signal(fault.signo),
+		Addr:   uintptr(fault.addr),
+		PC:     uintptr(fault.pc),
+	}
+	if si, serr := LookupAddr(unsafe.Pointer(fault.pc)); serr == nil {
+		err.Symbol = si.String()
+	}
+	return err
+}
+
+// EOF
@@ -0,0 +1,99 @@
+package ffi_test
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestCallGuarded(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//size_t strlen(const char *s);
+	strlen, err := lib.FctPtr("strlen")
+	if err != nil {
+		t.Fatalf("could not locate function [strlen]: %v", err)
+	}
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := cif.CallGuarded(strlen, "hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, uint64(5), out.Uint())
+
+	for i := 0; i < 3; i++ {
+		_, err = cif.CallGuarded(strlen, uintptr(8))
+		ferr, ok := err.(*ffi.FaultError)
+		if !ok {
+			t.Fatalf("expected a *ffi.FaultError, got %T (%v)", err, err)
+		}
+		eq(t, syscall.SIGSEGV, ferr.Signal)
+		eq(t, uintptr(8), ferr.Addr)
+		// strlen may be resolved to a non-exported implementation
+		if !strings.Contains(ferr.Symbol, "strlen") && !strings.Contains(ferr.Symbol, "libc") {
+			t.Errorf("expected the fault in strlen, got %q", ferr.Symbol)
+		}
+	}
+
+	// the process is still usable
+	out, err = cif.CallGuarded(strlen, "hello, world")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, uint64(12), out.Uint())
+}
+
+func TestCallGuardedCallback(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//void qsort(void *base, size_t nmemb, size_t size,
+	//           int (*compar)(const void *, const void *));
+	qsort, err := lib.FctPtr("qsort")
+	if err != nil {
+		t.Fatalf("could not locate function [qsort]: %v", err)
+	}
+	cif, err := ffi.NewCif(ffi.DefaultAbi, ffi.C_void,
+		[]ffi.Type{ffi.C_pointer, ffi.C_uint64, ffi.C_uint64, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// go panics in callbacks are left to the go runtime.
+	cmp, err := ffi.NewCallback(
+		func(a, b unsafe.Pointer) int32 {
+			var p *int32
+			return *p
+		},
+		ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_pointer},
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cmp.Free()
+
+	data := []int32{3, 1, 2}
+	_, err = cif.CallGuarded(qsort, unsafe.Pointer(&data[0]), uint64(len(data)), uint64(4), cmp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmp.Err() == nil {
+		t.Errorf("expected the callback to record a panic")
+	}
+}
+
+// EOF
@@ -0,0 +1,273 @@
+package ffi
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// ELF constants missing from debug/elf
+const (
+	g_stb_gnu_unique  elf.SymBind = 10
+	g_nt_gnu_build_id uint32      = 3
+)
+
+// Symbol describes a symbol exported by a shared library.
+type Symbol struct {
+	Name    string
+	Type    elf.SymType // STT_FUNC, STT_GNU_IFUNC, STT_OBJECT, STT_TLS, ...
+	Binding elf.SymBind // STB_GLOBAL, STB_WEAK or STB_GNU_UNIQUE (10)
+	Size    uint64
+	Version string // version of the symbol ("GLIBC_2.2.5"), if any
+	Hidden  bool   // whether Version is not the default version of Name
+}
+
+// IsFunc returns whether the symbol is a function.
+func (s Symbol) IsFunc() bool {
+	return s.Type == elf.STT_FUNC || s.Type == elf.STT_GNU_IFUNC
+}
+
+// LibraryInfo describes an ELF shared library.
+type LibraryInfo struct {
+	Path    string
+	Soname  string
+	RPath   []string // DT_RPATH search path
+	RunPath []string // DT_RUNPATH search path
+	BuildID string   // GNU build-id, hex-encoded
+	Symbols []Symbol // exported dynamic symbols
+}
+
+// InspectFile parses the ELF shared library at path.
+func InspectFile(path string) (*LibraryInfo, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &LibraryInfo{Path: path}
+	if sonames, err := f.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+		info.Soname = sonames[0]
+	}
+	info.RPath = dyn_path(f, elf.DT_RPATH)
+	info.RunPath = dyn_path(f, elf.DT_RUNPATH)
+	info.BuildID = build_id(f)
+
+	syms, err := f.DynamicSymbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	for _, s := range syms {
+		bind := elf.ST_BIND(s.Info)
+		if s.Section == elf.SHN_UNDEF || s.Name == "" {
+			continue
+		}
+		if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK && bind != g_stb_gnu_unique {
+			continue
+		}
+		switch elf.ST_VISIBILITY(s.Other) {
+		case elf.STV_HIDDEN, elf.STV_INTERNAL:
+			continue
+		}
+		info.Symbols = append(info.Symbols, Symbol{
+			Name:    s.Name,
+			Type:    elf.ST_TYPE(s.Info),
+			Binding: bind,
+			Size:    s.Size,
+			Version: s.Version,
+			Hidden:  s.HasVersion && s.VersionIndex.IsHidden(),
+		})
+	}
+	return info, nil
+}
+
+// dyn_path returns the search path listed in f for tag.
+func dyn_path(f *elf.File, tag elf.DynTag) []string {
+	strs, err := f.DynString(tag)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, s := range strs {
+		dirs = append(dirs, filepath.SplitList(s)...)
+	}
+	return dirs
+}
+
+// build_id returns the hex-encoded GNU build-id of f, or "".
+func build_id(f *elf.File) string {
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		data := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(data, 0); err != nil {
+			continue
+		}
+		for len(data) >= 12 {
+			namesz := int(f.ByteOrder.Uint32(data[0:]))
+			descsz := int(f.ByteOrder.Uint32(data[4:]))
+			typ := f.ByteOrder.Uint32(data[8:])
+			data = data[12:]
+			nameoff := align4(namesz)
+			if nameoff < 0 || len(data) < nameoff+descsz {
+				break
+			}
+			if typ == g_nt_gnu_build_id && namesz == 4 && string(data[:4]) == "GNU\x00" {
+				return hex.EncodeToString(data[nameoff : nameoff+descsz])
+			}
+			next := nameoff + align4(descsz)
+			if next > len(data) {
+				next = len(data)
+			}
+			data = data[next:]
+		}
+	}
+	return ""
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// Inspect parses the shared library file of lib.
+func (lib Library) Inspect() (*LibraryInfo, error) {
+	info, err := lib.elf_info()
+	if err != nil {
+		return nil, err
+	}
+	cpy := *info
+	cpy.Symbols = append([]Symbol(nil), info.Symbols...)
+	return &cpy, nil
+}
+
+// Symbols returns the symbols exported by the shared library file of lib.
+func (lib Library) Symbols() ([]Symbol, error) {
+	info, err := lib.elf_info()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Symbol(nil), info.Symbols...), nil
+}
+
+// g_elf_infos caches the descriptions of the libraries, by path
+var g_elf_infos = struct {
+	sync.Mutex
+	infos map[string]*LibraryInfo
+}{infos: make(map[string]*LibraryInfo)}
+
+// elf_info returns the description of the shared library file of lib.
+func (lib Library) elf_info() (*LibraryInfo, error) {
+	if lib.backend != nil || lib.path == "" {
+		return nil, fmt.Errorf("ffi: no shared library file for this library")
+	}
+	g_elf_infos.Lock()
+	defer g_elf_infos.Unlock()
+	if info, ok := g_elf_infos.infos[lib.path]; ok {
+		return info, nil
+	}
+	info, err := InspectFile(lib.path)
+	if err != nil {
+		return nil, err
+	}
+	g_elf_infos.infos[lib.path] = info
+	return info, nil
+}
+
+// check_function returns an error if fctname, found at addr, is an
+// exported symbol which is not a function.
+func check_function(fctname string, addr unsafe.Pointer) error {
+	typ, ok := symbol_type(addr)
+	if !ok {
+		return nil
+	}
+	switch typ {
+	case elf.STT_OBJECT, elf.STT_TLS, elf.STT_COMMON:
+		return fmt.Errorf("ffi: [%s] is not a function but a %s symbol (see Library.Var)", fctname, typ)
+	}
+	return nil
+}
+
+// symbol_error annotates err, the failure to find the function fctname,
+// with the functions of lib of similar names.
+func (lib Library) symbol_error(fctname string, err error) error {
+	info, ierr := lib.elf_info()
+	if ierr != nil {
+		return err
+	}
+	names := info.suggest(fctname, 3)
+	if len(names) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (did you mean %s?)", err, strings.Join(names, ", "))
+}
+
+// suggest returns up to n exported functions with names close to name.
+func (info *LibraryInfo) suggest(name string, n int) []string {
+	type match struct {
+		name string
+		dist int
+	}
+	lname := strings.ToLower(name)
+	max_dist := len(name) / 3
+	if max_dist < 1 {
+		max_dist = 1
+	}
+	var matches []match
+	seen := make(map[string]bool)
+	for _, s := range info.Symbols {
+		if !s.IsFunc() || seen[s.Name] {
+			continue
+		}
+		seen[s.Name] = true
+		d := edit_distance(lname, strings.ToLower(s.Name))
+		if d <= max_dist {
+			matches = append(matches, match{s.Name, d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+	var names []string
+	for i := 0; i < len(matches) && i < n; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+// edit_distance returns the Levenshtein distance between a and b.
+func edit_distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// EOF
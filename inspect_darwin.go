@@ -0,0 +1,13 @@
+package ffi
+
+import (
+	"debug/elf"
+	"unsafe"
+)
+
+// symbol_type returns false: darwin libraries are not ELF files.
+func symbol_type(addr unsafe.Pointer) (elf.SymType, bool) {
+	return 0, false
+}
+
+// EOF
@@ -0,0 +1,37 @@
+package ffi
+
+// #define _GNU_SOURCE
+// #include <dlfcn.h>
+// #include <link.h>
+//
+// // _go_ffi_symbol_type returns the ELF type of the exported symbol at
+// // addr, or -1 if unknown.
+// static int _go_ffi_symbol_type(void *addr)
+// {
+//   Dl_info info;
+//   const ElfW(Sym) *sym = NULL;
+//   if (dladdr1(addr, &info, (void**)&sym, RTLD_DL_SYMENT) == 0 || sym == NULL) {
+//     return -1;
+//   }
+//   if (info.dli_saddr != addr) {
+//     return -1;
+//   }
+//   return ELF64_ST_TYPE(sym->st_info);
+// }
+import "C"
+
+import (
+	"debug/elf"
+	"unsafe"
+)
+
+// symbol_type returns the ELF type of the exported symbol at addr.
+func symbol_type(addr unsafe.Pointer) (elf.SymType, bool) {
+	typ := C._go_ffi_symbol_type(addr)
+	if typ < 0 {
+		return 0, false
+	}
+	return elf.SymType(typ), true
+}
+
+// EOF
@@ -0,0 +1,85 @@
+package ffi_test
+
+import (
+	"debug/elf"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestInspect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("ELF shared libraries only")
+	}
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	info, err := lib.Inspect()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, "libc.so.6", info.Soname)
+	if !strings.HasPrefix(info.Path, "/") {
+		t.Errorf("expected an absolute path (got %q)", info.Path)
+	}
+
+	info2, err := ffi.InspectFile(info.Path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, info.BuildID, info2.BuildID)
+	eq(t, len(info.Symbols), len(info2.Symbols))
+
+	syms, err := lib.Symbols()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	kinds := make(map[string]ffi.Symbol)
+	for _, s := range syms {
+		if !s.Hidden {
+			kinds[s.Name] = s
+		}
+	}
+	if s := kinds["strlen"]; !s.IsFunc() {
+		t.Errorf("expected [strlen] to be a function (got %+v)", s)
+	}
+	if s := kinds["optind"]; s.Type != elf.STT_OBJECT || s.Size != 4 || s.IsFunc() {
+		t.Errorf("expected [optind] to be a 4 bytes object (got %+v)", s)
+	}
+	if s := kinds["strlen"]; s.Version == "" {
+		t.Errorf("expected [strlen] to be versioned (got %+v)", s)
+	}
+
+	_, err = ffi.NewMockLibrary().Symbols()
+	if err == nil {
+		t.Errorf("expected an error inspecting a mock library")
+	}
+}
+
+func TestFctSymbolErrors(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("ELF shared libraries only")
+	}
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	_, err = lib.Fct("optind", ffi.C_int32, nil)
+	if err == nil || !strings.Contains(err.Error(), "not a function") {
+		t.Errorf("expected an error binding a data symbol (got %v)", err)
+	}
+
+	_, err = lib.Fct("strlenn", ffi.C_int32, []ffi.Type{ffi.C_pointer})
+	if err == nil || !strings.Contains(err.Error(), "did you mean") || !strings.Contains(err.Error(), "strlen") {
+		t.Errorf("expected suggestions for a misspelled function (got %v)", err)
+	}
+}
+
+// EOF
@@ -0,0 +1,253 @@
+package ffi
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime/trace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// CallInfo describes a call through an intercepted Function.
+// Result, Err, Errno and Duration are set once the call was performed.
+type CallInfo struct {
+	Symbol   string        // name of the called C function
+	RType    Type          // declared result type, nil if unknown
+	ArgTypes []Type        // declared (fixed) argument types, nil if unknown
+	Args     []interface{} // arguments of the call
+	Result   reflect.Value // result of the call
+	Err      error         // error preventing the call, if any
+	Errno    syscall.Errno // errno after the call, for ErrnoFunctions
+	Duration time.Duration // time spent performing the call
+}
+
+// Invoker performs the call described by ci, or passes it on to the next
+// interceptor.
+type Invoker func(ci *CallInfo) (reflect.Value, error)
+
+// Interceptor wraps the calls through a Function.
+// It is expected to call next, possibly after modifying ci.Args, and to
+// return its results.
+type Interceptor func(ci *CallInfo, next Invoker) (reflect.Value, error)
+
+// Use returns a copy of lib whose functions run the interceptors ics around
+// their calls, after the interceptors of lib. Interceptors run in the order
+// they were added.
+func (lib Library) Use(ics ...Interceptor) *Library {
+	// do not share the backing array with other copies of lib
+	lib.interceptors = append(lib.interceptors[:len(lib.interceptors):len(lib.interceptors)], ics...)
+	return &lib
+}
+
+// chain returns an Invoker running the interceptors ics around core.
+func chain(ics []Interceptor, core Invoker) Invoker {
+	inv := core
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], inv
+		inv = func(ci *CallInfo) (reflect.Value, error) {
+			return ic(ci, next)
+		}
+	}
+	return inv
+}
+
+// WithInterceptors returns a Function calling fct, the C function symbol,
+// through the interceptors ics.
+func (fct Function) WithInterceptors(symbol string, ics ...Interceptor) Function {
+	return fct.intercept(CallInfo{Symbol: symbol}, ics)
+}
+
+// intercept returns a Function calling fct through the interceptors ics,
+// describing the calls with info.
+func (fct Function) intercept(info CallInfo, ics []Interceptor) Function {
+	if len(ics) == 0 {
+		return fct
+	}
+	inv := chain(ics, func(ci *CallInfo) (reflect.Value, error) {
+		start := time.Now()
+		ci.Result, ci.Err = fct.Call(ci.Args...)
+		ci.Duration = time.Since(start)
+		return ci.Result, ci.Err
+	})
+	return func(args ...interface{}) reflect.Value {
+		ci := info
+		ci.Args = args
+		out, err := inv(&ci)
+		if err != nil {
+			panic(err)
+		}
+		return out
+	}
+}
+
+// WithInterceptors returns an ErrnoFunction calling fct, the C function
+// symbol, through the interceptors ics.
+func (fct ErrnoFunction) WithInterceptors(symbol string, ics ...Interceptor) ErrnoFunction {
+	return fct.intercept(CallInfo{Symbol: symbol}, ics)
+}
+
+// intercept returns an ErrnoFunction calling fct through the interceptors
+// ics, describing the calls with info.
+func (fct ErrnoFunction) intercept(info CallInfo, ics []Interceptor) ErrnoFunction {
+	if len(ics) == 0 {
+		return fct
+	}
+	inv := chain(ics, func(ci *CallInfo) (reflect.Value, error) {
+		start := time.Now()
+		ci.Result, ci.Errno, ci.Err = fct.Call(ci.Args...)
+		ci.Duration = time.Since(start)
+		return ci.Result, ci.Err
+	})
+	return func(args ...interface{}) (reflect.Value, syscall.Errno) {
+		ci := info
+		ci.Args = args
+		out, err := inv(&ci)
+		if err != nil {
+			panic(err)
+		}
+		return out, ci.Errno
+	}
+}
+
+// SlogInterceptor returns an Interceptor logging every call with logger
+// (slog.Default() if nil), at the given level. Failed calls are logged at
+// the error level.
+func SlogInterceptor(logger *slog.Logger, level slog.Level) Interceptor {
+	return func(ci *CallInfo, next Invoker) (reflect.Value, error) {
+		logger := logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		out, err := next(ci)
+		lvl := level
+		if err != nil {
+			lvl = slog.LevelError
+		}
+		ctx := context.Background()
+		if !logger.Enabled(ctx, lvl) {
+			return out, err
+		}
+		attrs := []slog.Attr{
+			slog.String("symbol", ci.Symbol),
+			slog.Any("args", ci.Args),
+			slog.Duration("duration", ci.Duration),
+		}
+		if out.IsValid() {
+			attrs = append(attrs, slog.Any("result", out.Interface()))
+		}
+		if ci.Errno != 0 {
+			attrs = append(attrs, slog.Any("errno", ci.Errno))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		logger.LogAttrs(ctx, lvl, "ffi call", attrs...)
+		return out, err
+	}
+}
+
+// ExpvarInterceptor returns an Interceptor publishing, under the expvar
+// name, the number of calls, of failed calls and a latency histogram for
+// every called symbol:
+//
+//	{"cos": {"calls": 2, "errors": 0, "latency": {"1µs": 2, "10µs": 0, ...}}}
+//
+// Interceptors sharing the same name share the same counters.
+func ExpvarInterceptor(name string) Interceptor {
+	g_expvars.Lock()
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		m = expvar.NewMap(name)
+	}
+	g_expvars.Unlock()
+
+	return func(ci *CallInfo, next Invoker) (reflect.Value, error) {
+		out, err := next(ci)
+		stats := symbol_stats(m, ci.Symbol)
+		stats.Add("calls", 1)
+		if err != nil {
+			stats.Add("errors", 1)
+		} else {
+			stats.Get("latency").(*latency_histogram).observe(ci.Duration)
+		}
+		return out, err
+	}
+}
+
+// g_expvars protects the creation of the expvar maps
+var g_expvars sync.Mutex
+
+// symbol_stats returns the statistics of symbol, in m.
+func symbol_stats(m *expvar.Map, symbol string) *expvar.Map {
+	if stats, ok := m.Get(symbol).(*expvar.Map); ok {
+		return stats
+	}
+	g_expvars.Lock()
+	defer g_expvars.Unlock()
+	if stats, ok := m.Get(symbol).(*expvar.Map); ok {
+		return stats
+	}
+	stats := new(expvar.Map).Init()
+	stats.Add("calls", 0)
+	stats.Add("errors", 0)
+	stats.Set("latency", new(latency_histogram))
+	m.Set(symbol, stats)
+	return stats
+}
+
+// the upper bounds of the buckets of a latency_histogram
+var g_latency_buckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// latency_histogram counts durations in buckets of increasing upper bounds.
+// The last bucket holds the durations above all bounds.
+type latency_histogram struct {
+	counts [8]atomic.Int64
+}
+
+func (h *latency_histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(g_latency_buckets) && d > g_latency_buckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+}
+
+// String returns the histogram as a JSON object, keyed by upper bounds.
+func (h *latency_histogram) String() string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, bound := range g_latency_buckets {
+		fmt.Fprintf(&b, "%q: %d, ", bound.String(), h.counts[i].Load())
+	}
+	fmt.Fprintf(&b, "%q: %d}", "inf", h.counts[len(g_latency_buckets)].Load())
+	return b.String()
+}
+
+// TraceInterceptor returns an Interceptor wrapping every call into a
+// runtime/trace region named after the called symbol, so that the time
+// spent in C code shows up in 'go tool trace'.
+func TraceInterceptor() Interceptor {
+	return func(ci *CallInfo, next Invoker) (reflect.Value, error) {
+		if !trace.IsEnabled() {
+			return next(ci)
+		}
+		defer trace.StartRegion(context.Background(), "ffi:"+ci.Symbol).End()
+		return next(ci)
+	}
+}
+
+// EOF
@@ -0,0 +1,168 @@
+package ffi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime/trace"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestInterceptors(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	var calls []string
+	trace := func(tag string) ffi.Interceptor {
+		return func(ci *ffi.CallInfo, next ffi.Invoker) (reflect.Value, error) {
+			calls = append(calls, tag+":"+ci.Symbol)
+			out, err := next(ci)
+			calls = append(calls, tag+":done")
+			return out, err
+		}
+	}
+	var last ffi.CallInfo
+	lib = lib.Use(trace("a"), trace("b"), func(ci *ffi.CallInfo, next ffi.Invoker) (reflect.Value, error) {
+		// arguments can be rewritten
+		if x, ok := ci.Args[0].(float64); ok {
+			ci.Args = []interface{}{2 * x}
+		}
+		out, err := next(ci)
+		last = *ci
+		return out, err
+	})
+
+	//double fabs(double x);
+	fabs, err := lib.Fct("fabs", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [fabs]: %v", err)
+	}
+	eq(t, 3.0, fabs(-1.5).Float())
+	eq(t, []string{"a:fabs", "b:fabs", "b:done", "a:done"}, calls)
+	eq(t, "fabs", last.Symbol)
+	eq(t, []interface{}{-3.0}, last.Args)
+	eq(t, 3.0, last.Result.Float())
+	if last.Err != nil || last.Duration <= 0 {
+		t.Errorf("invalid call info: %+v", last)
+	}
+
+	_, err = fabs.Call("not a double")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if last.Err != err {
+		t.Errorf("expected the call info to hold the error %v (got %v)", err, last.Err)
+	}
+}
+
+func TestSlogInterceptor(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	buf := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(buf, nil))
+	lib = lib.Use(ffi.SlogInterceptor(logger, slog.LevelInfo))
+
+	//double fabs(double x);
+	fabs, err := lib.Fct("fabs", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [fabs]: %v", err)
+	}
+	fabs(-2.0)
+	fabs.Call()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+	for _, sub := range []string{"level=INFO", `msg="ffi call"`, "symbol=fabs", "args=[-2]", "result=2", "duration="} {
+		if !strings.Contains(lines[0], sub) {
+			t.Errorf("expected %q in %q", sub, lines[0])
+		}
+	}
+	for _, sub := range []string{"level=ERROR", "symbol=fabs", "error="} {
+		if !strings.Contains(lines[1], sub) {
+			t.Errorf("expected %q in %q", sub, lines[1])
+		}
+	}
+}
+
+func TestExpvarInterceptor(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	// expvars are never unregistered: do not count the calls of other runs
+	name := fmt.Sprintf("ffi_test_calls_%d", time.Now().UnixNano())
+	lib = lib.Use(ffi.ExpvarInterceptor(name))
+
+	//double fabs(double x);
+	fabs, err := lib.Fct("fabs", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [fabs]: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		fabs(-1.0)
+	}
+	fabs.Call()
+
+	var stats map[string]struct {
+		Calls   int64
+		Errors  int64
+		Latency map[string]int64
+	}
+	err = json.Unmarshal([]byte(expvar.Get(name).String()), &stats)
+	if err != nil {
+		t.Fatalf("invalid expvar: %v", err)
+	}
+	eq(t, int64(4), stats["fabs"].Calls)
+	eq(t, int64(1), stats["fabs"].Errors)
+	n := int64(0)
+	for _, c := range stats["fabs"].Latency {
+		n += c
+	}
+	eq(t, int64(3), n)
+}
+
+func TestTraceInterceptor(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	lib = lib.Use(ffi.TraceInterceptor())
+
+	//double fabs(double x);
+	fabs, err := lib.Fct("fabs", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [fabs]: %v", err)
+	}
+	eq(t, 1.0, fabs(-1.0).Float())
+
+	buf := new(bytes.Buffer)
+	err = trace.Start(buf)
+	if err != nil {
+		t.Skipf("could not start tracing: %v", err)
+	}
+	eq(t, 1.0, fabs(-1.0).Float())
+	trace.Stop()
+	if !bytes.Contains(buf.Bytes(), []byte("ffi:fabs")) {
+		t.Errorf("expected a ffi:fabs region in the trace")
+	}
+}
+
+// EOF
@@ -0,0 +1,231 @@
+package ffi
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// ErrLibraryClosed is returned when using a Library, or calling one of its
+// functions, after the Library was closed.
+var ErrLibraryClosed = errors.New("ffi: library closed")
+
+// lib_state is the lifetime of a library, shared by the copies of a Library
+// (see WithPolicy) and by its functions.
+//
+// The library is unloaded once it is closed and no reference is held:
+// calls in flight and explicit Retains hold references, as do the lib_refs
+// of the values derived from the library.
+type lib_state struct {
+	mu       sync.Mutex
+	refs     int  // references held by calls in flight and Retain
+	held     int  // references held by lib_refs
+	closed   bool // whether Close was called
+	unloaded bool // whether unload was called
+
+	unload func() error // unloads the library, nil if there is nothing to unload
+}
+
+// new_lib_state returns the lifetime of a library unloaded by unload.
+// A finalizer unloads the library if it becomes unreachable before being
+// closed.
+func new_lib_state(unload func() error) *lib_state {
+	st := &lib_state{unload: unload}
+	if unload != nil {
+		runtime.SetFinalizer(st, (*lib_state).finalize)
+	}
+	return st
+}
+
+func (st *lib_state) finalize() {
+	st.mu.Lock()
+	done := st.unloaded
+	st.unloaded = true
+	st.mu.Unlock()
+	if !done {
+		st.unload()
+	}
+}
+
+// acquire takes a reference on the library, if not closed.
+func (st *lib_state) acquire() error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return ErrLibraryClosed
+	}
+	st.refs++
+	return nil
+}
+
+// release drops a reference on the library, unloading it if it was the last
+// one of a closed library.
+func (st *lib_state) release() error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	if st.refs <= 0 {
+		st.mu.Unlock()
+		panic("ffi: Library.Release without Retain")
+	}
+	st.refs--
+	return st.unload_unused()
+}
+
+// unload_unused unlocks st, then unloads the library if it was closed and
+// no reference is held.
+func (st *lib_state) unload_unused() error {
+	last := st.closed && st.refs == 0 && st.held == 0 && !st.unloaded
+	if last {
+		st.unloaded = true
+	}
+	st.mu.Unlock()
+	if !last || st.unload == nil {
+		return nil
+	}
+	runtime.SetFinalizer(st, nil)
+	return st.unload()
+}
+
+// close marks the library closed, unloading it if no reference is held.
+func (st *lib_state) close() error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return ErrLibraryClosed
+	}
+	st.closed = true
+	return st.unload_unused()
+}
+
+// lib_ref is a reference on a library held by a value derived from it: a
+// Value returned by Var, a FctPtr returned by FctPtr, or a Callback passed
+// to one of its functions. It is released by a finalizer once unreachable,
+// or explicitly by release (see Callback.Free).
+type lib_ref struct {
+	st   *lib_state
+	once sync.Once
+}
+
+// ref returns a new reference on the library, or nil if it is closed.
+func (st *lib_state) ref() *lib_ref {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return nil
+	}
+	st.held++
+	r := &lib_ref{st: st}
+	runtime.SetFinalizer(r, (*lib_ref).release)
+	return r
+}
+
+// release drops the reference, unloading the library if it was the last
+// one of a closed library.
+func (r *lib_ref) release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		runtime.SetFinalizer(r, nil)
+		r.st.mu.Lock()
+		r.st.held--
+		r.st.unload_unused()
+	})
+}
+
+// is_closed returns whether the library was closed.
+func (st *lib_state) is_closed() bool {
+	if st == nil {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.closed
+}
+
+// Close closes the library: its functions then fail with ErrLibraryClosed.
+// The library is unloaded once no call is in flight, all the references
+// taken with Retain are released and the Callbacks passed to its functions
+// are freed.
+// The Values returned by Var and the FctPtrs returned by FctPtr alias the
+// memory of the library: they defer the unloading until they are
+// unreachable, which is up to the garbage collector. Use Retain and Release
+// to control when the library is unloaded.
+func (lib Library) Close() error {
+	return lib.state.close()
+}
+
+// Retain takes a reference on lib, deferring the unloading of the library
+// past Close until the matching Release, e.g. while C code or raw pointers
+// (see Value.Buffer) use the library outside of the values derived from it.
+// It returns ErrLibraryClosed if lib was closed.
+func (lib Library) Retain() error {
+	return lib.state.acquire()
+}
+
+// Release drops a reference taken with Retain, unloading the library if it
+// was closed and this was the last reference.
+func (lib Library) Release() error {
+	return lib.state.release()
+}
+
+// guard returns a Function calling fct while holding a reference on the
+// library st, failing with ErrLibraryClosed once it is closed.
+// The Callbacks it is called with keep the library loaded until they are
+// freed.
+func (fct Function) guard(st *lib_state) Function {
+	if st == nil {
+		return fct
+	}
+	return func(args ...interface{}) reflect.Value {
+		if err := st.acquire(); err != nil {
+			panic(err)
+		}
+		defer st.release()
+		hold_callbacks(st, args)
+		return fct(args...)
+	}
+}
+
+// guard returns an ErrnoFunction calling fct while holding a reference on
+// the library st, failing with ErrLibraryClosed once it is closed.
+// The Callbacks it is called with keep the library loaded until they are
+// freed.
+func (fct ErrnoFunction) guard(st *lib_state) ErrnoFunction {
+	if st == nil {
+		return fct
+	}
+	return func(args ...interface{}) (reflect.Value, syscall.Errno) {
+		if err := st.acquire(); err != nil {
+			panic(err)
+		}
+		defer st.release()
+		hold_callbacks(st, args)
+		return fct(args...)
+	}
+}
+
+// hold_callbacks makes the Callbacks among args hold a reference on the
+// library st: the library may call them until they are freed.
+func hold_callbacks(st *lib_state, args []interface{}) {
+	for _, arg := range args {
+		if cb, ok := arg.(*Callback); ok {
+			cb.hold(st)
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,210 @@
+package ffi_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestLibraryClose(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cos, err := lib.Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 1.0, cos(0.0).Float())
+
+	err = lib.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, err = cos.Call(0.0)
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed calling a function of a closed library (got %v)", err)
+	}
+	_, err = lib.Fct("sin", ffi.C_double, []ffi.Type{ffi.C_double})
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed resolving a function of a closed library (got %v)", err)
+	}
+	_, err = lib.Var("signgam", ffi.C_int32)
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed resolving a variable of a closed library (got %v)", err)
+	}
+	if lib.Has("cos") {
+		t.Errorf("expected a closed library not to provide functions")
+	}
+	err = lib.Close()
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed closing a library twice (got %v)", err)
+	}
+	err = lib.Retain()
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed retaining a closed library (got %v)", err)
+	}
+}
+
+func TestLibraryCloseInFlight(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	lib := mock.WithPolicy(ffi.FreePolicy)
+	var cerr error
+	mock.Define("shutdown", func() int32 {
+		// closing the library while one of its functions runs
+		cerr = lib.Close()
+		return 42
+	})
+	shutdown, err := lib.Fct("shutdown", ffi.C_int32, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := shutdown.Call()
+	if err != nil {
+		t.Fatalf("expected the call in flight to complete (got %v)", err)
+	}
+	eq(t, int64(42), out.Int())
+	if cerr != nil {
+		t.Errorf("closing a library with a call in flight: %v", cerr)
+	}
+	_, err = shutdown.Call()
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed (got %v)", err)
+	}
+
+	// copies share the lifetime of the library
+	_, err = mock.Fct("shutdown", ffi.C_int32, nil)
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed from a copy of a closed library (got %v)", err)
+	}
+}
+
+func TestLibraryRetain(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	optind, err := lib.Var("optind", ffi.C_int32)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = lib.Retain()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = lib.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// still loaded until released
+	_ = optind.Int()
+	err = lib.Release()
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected a panic releasing a library without a reference")
+			}
+		}()
+		lib.Release()
+	}()
+}
+
+func TestLibraryCloseReferences(t *testing.T) {
+	if ffi.IsLoaded(libbz2_name) {
+		t.Skipf("[%s] is already loaded", libbz2_name)
+	}
+	lib, err := ffi.NewLibrary(libbz2_name)
+	if err != nil {
+		t.Skipf("%v", err)
+	}
+	table, err := lib.Var("BZ2_crc32Table", ffi.C_uint32)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fct, err := lib.FctPtr("BZ2_bzlibVersion")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	version, err := lib.Fct("BZ2_bzlibVersion", ffi.C_pointer, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	err = lib.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// the Var and the FctPtr keep the library loaded
+	if !ffi.IsLoaded(libbz2_name) {
+		t.Fatalf("expected [%s] to stay loaded while referenced", libbz2_name)
+	}
+	eq(t, uint64(0), table.Uint())
+	bzlib_version, err := ffi.MakeFunction(fct, ffi.C_pointer, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if bzlib_version().Uint() == 0 {
+		t.Errorf("expected a version string")
+	}
+	_, err = version.Call()
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed (got %v)", err)
+	}
+	runtime.KeepAlive(table)
+	runtime.KeepAlive(bzlib_version)
+
+	// and unloaded once unreachable
+	for i := 0; i < 50 && ffi.IsLoaded(libbz2_name); i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ffi.IsLoaded(libbz2_name) {
+		t.Errorf("expected [%s] to be unloaded", libbz2_name)
+	}
+}
+
+func TestLibraryCloseFunctions(t *testing.T) {
+	if ffi.IsLoaded(libbz2_name) {
+		t.Skipf("[%s] is already loaded", libbz2_name)
+	}
+	lib, err := ffi.NewLibrary(libbz2_name)
+	if err != nil {
+		t.Skipf("%v", err)
+	}
+	version, err := lib.Fct("BZ2_bzlibVersion", ffi.C_pointer, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, err := version.Call()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if out.Uint() == 0 {
+		t.Errorf("expected a version string")
+	}
+
+	err = lib.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// no call is in flight: the library is unloaded right away, even
+	// though the Function is still reachable
+	if ffi.IsLoaded(libbz2_name) {
+		t.Errorf("expected [%s] to be unloaded", libbz2_name)
+	}
+	_, err = version.Call()
+	if !errors.Is(err, ffi.ErrLibraryClosed) {
+		t.Errorf("expected ErrLibraryClosed (got %v)", err)
+	}
+	runtime.KeepAlive(version)
+}
+
+// EOF
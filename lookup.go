@@ -0,0 +1,91 @@
+package ffi
+
+// #define _GNU_SOURCE
+// #include <dlfcn.h>
+// #include <stdint.h>
+// #ifdef __GLIBC__
+// #include <link.h>
+// #endif
+//
+// typedef struct {
+//   const char *fname; // path of the shared object
+//   void *fbase;       // base address of the shared object
+//   const char *sname; // nearest symbol, or NULL
+//   void *saddr;       // address of the nearest symbol
+//   uint64_t size;     // size of the nearest symbol, 0 if unknown
+// } _go_ffi_addr_info_t;
+//
+// static int _go_ffi_lookup_addr(void *addr, _go_ffi_addr_info_t *out)
+// {
+//   Dl_info info;
+// #ifdef __GLIBC__
+//   const ElfW(Sym) *sym = NULL;
+//   if (dladdr1(addr, &info, (void**)&sym, RTLD_DL_SYMENT) == 0) {
+//     return 0;
+//   }
+//   out->size = (sym != NULL && info.dli_sname != NULL) ? sym->st_size : 0;
+// #else
+//   if (dladdr(addr, &info) == 0) {
+//     return 0;
+//   }
+//   out->size = 0;
+// #endif
+//   out->fname = info.dli_fname;
+//   out->fbase = info.dli_fbase;
+//   out->sname = info.dli_sname;
+//   out->saddr = info.dli_saddr;
+//   return 1;
+// }
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// SymbolInfo locates an address in the loaded shared objects.
+type SymbolInfo struct {
+	Path   string  // path of the shared object containing the address
+	Base   uintptr // base address of the shared object
+	Symbol string  // nearest exported symbol, "" if none
+	Addr   uintptr // address of Symbol
+	Size   uint64  // size of Symbol, 0 if unknown
+	Offset uintptr // offset of the address from Symbol, or from Base
+}
+
+// String returns the location as "sym" or "sym+0x1c", or as
+// "/lib/libfoo.so+0x1c4f0" if no exported symbol is known.
+func (si SymbolInfo) String() string {
+	name := si.Symbol
+	if name == "" {
+		name = si.Path
+	}
+	if si.Offset == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s+0x%x", name, si.Offset)
+}
+
+// LookupAddr returns the shared object and the nearest symbol containing
+// ptr, e.g. to name a function pointer.
+func LookupAddr(ptr unsafe.Pointer) (SymbolInfo, error) {
+	var info C._go_ffi_addr_info_t
+	if ptr == nil || C._go_ffi_lookup_addr(ptr, &info) == 0 {
+		return SymbolInfo{}, fmt.Errorf("ffi: no shared object contains address %p", ptr)
+	}
+	si := SymbolInfo{
+		Path: C.GoString(info.fname),
+		Base: uintptr(info.fbase),
+		Size: uint64(info.size),
+	}
+	if info.sname != nil {
+		si.Symbol = C.GoString(info.sname)
+		si.Addr = uintptr(info.saddr)
+		si.Offset = uintptr(ptr) - si.Addr
+	} else {
+		si.Offset = uintptr(ptr) - si.Base
+	}
+	return si, nil
+}
+
+// EOF
@@ -0,0 +1,55 @@
+package ffi_test
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestLookupAddr(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	abs, err := lib.FctPtr("abs")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	si, err := ffi.LookupAddr(abs.Pointer())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, "abs", si.Symbol)
+	eq(t, uintptr(abs.Pointer()), si.Addr)
+	eq(t, uintptr(0), si.Offset)
+	eq(t, "abs", si.String())
+	if !strings.Contains(si.Path, "libc") {
+		t.Errorf("expected [abs] in libc (got %q)", si.Path)
+	}
+	if si.Base == 0 || si.Base > si.Addr {
+		t.Errorf("invalid base address 0x%x for symbol at 0x%x", si.Base, si.Addr)
+	}
+
+	// inside the function
+	si2, err := ffi.LookupAddr(unsafe.Pointer(uintptr(abs.Pointer()) + 1))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, "abs+0x1", si2.String())
+
+	_, err = ffi.LookupAddr(nil)
+	if err == nil {
+		t.Errorf("expected an error looking up a nil address")
+	}
+	var local int
+	_, err = ffi.LookupAddr(unsafe.Pointer(&local))
+	if err == nil {
+		t.Errorf("expected an error looking up a heap address")
+	}
+}
+
+// EOF
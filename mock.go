@@ -0,0 +1,172 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+// MockLibrary is a Library whose functions are go funcs registered with
+// Define, e.g. to test bindings without the real library.
+//
+// Calls go through the same conversions as calls of C functions: arguments
+// are converted to their C type, then to the parameter types of the go
+// func, as for a Callback. A go func may return a syscall.Errno as its last
+// result, reported by the ErrnoFunctions of the library.
+// The go func of a variadic function takes a final ...interface{}
+// parameter, receiving the promoted variadic arguments.
+type MockLibrary struct {
+	Library
+	mock *mock_backend
+}
+
+type mock_backend struct {
+	mu  sync.RWMutex
+	fns map[string]reflect.Value
+}
+
+// NewMockLibrary returns a new MockLibrary, without any function.
+func NewMockLibrary() *MockLibrary {
+	b := &mock_backend{fns: make(map[string]reflect.Value)}
+	return &MockLibrary{Library: Library{backend: b, state: new_lib_state(b.close)}, mock: b}
+}
+
+// Define registers fn, a go func, as the implementation of fctname.
+// It is type-checked against the signature given to Fct.
+func (m *MockLibrary) Define(fctname string, fn interface{}) error {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return fmt.Errorf("ffi.MockLibrary.Define: expected a func (got %T)", fn)
+	}
+	m.mock.mu.Lock()
+	m.mock.fns[fctname] = rv
+	m.mock.mu.Unlock()
+	return nil
+}
+
+func (b *mock_backend) has(fctname string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.fns[fctname]
+	return ok
+}
+
+func (b *mock_backend) close() error {
+	return nil
+}
+
+var (
+	g_errno_type = reflect.TypeOf(syscall.Errno(0))
+	g_iface_type = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+func (b *mock_backend) function(fctname string, rtype Type, argtypes []Type, variadic bool) (ErrnoFunction, error) {
+	b.mu.RLock()
+	fn, ok := b.fns[fctname]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("ffi: no mock for [%s]", fctname)
+	}
+
+	ft := fn.Type()
+	nin := ft.NumIn()
+	if variadic {
+		if !ft.IsVariadic() || ft.In(nin-1).Elem() != g_iface_type {
+			return nil, fmt.Errorf("ffi: mock for variadic [%s] should take a final ...interface{} (got %s)", fctname, ft)
+		}
+		nin--
+	} else if ft.IsVariadic() {
+		return nil, fmt.Errorf("ffi: mock for [%s] should not be variadic (got %s)", fctname, ft)
+	}
+	if nin != len(argtypes) {
+		return nil, fmt.Errorf("ffi: mock for [%s] should take %d arguments (got %s)", fctname, len(argtypes), ft)
+	}
+	for i, t := range argtypes {
+		if !is_callback_compatible(t, ft.In(i)) {
+			return nil, fmt.Errorf("ffi: mock for [%s]: argument #%d of type [%s] can not be converted to %s", fctname, i, t.Name(), ft.In(i))
+		}
+	}
+
+	nout := ft.NumOut()
+	with_errno := nout > 0 && ft.Out(nout-1) == g_errno_type
+	if with_errno {
+		nout--
+	}
+	switch {
+	case rtype.Kind() == Void && nout != 0:
+		return nil, fmt.Errorf("ffi: mock for [%s] returns a value, expected none (got %s)", fctname, ft)
+	case rtype.Kind() != Void && nout != 1:
+		return nil, fmt.Errorf("ffi: mock for [%s] should return exactly one value (got %s)", fctname, ft)
+	case rtype.Kind() != Void && ft.Out(0).Kind() == reflect.String:
+		return nil, fmt.Errorf("ffi: mock for [%s] can not return a go string", fctname)
+	case rtype.Kind() != Void && !is_callback_compatible(rtype, ft.Out(0)):
+		return nil, fmt.Errorf("ffi: mock for [%s]: result of type %s can not be converted to [%s]", fctname, ft.Out(0), rtype.Name())
+	}
+
+	fct := func(args ...interface{}) (reflect.Value, syscall.Errno) {
+		out, errno, err := mock_call(fn, rtype, argtypes, variadic, with_errno, args)
+		if err != nil {
+			panic(err)
+		}
+		return out, errno
+	}
+	return ErrnoFunction(fct), nil
+}
+
+// mock_call calls fn, the mock of a C function of the given signature, as
+// Cif.Call would call the C function.
+func mock_call(fn reflect.Value, rtype Type, argtypes []Type, variadic, with_errno bool, args []interface{}) (reflect.Value, syscall.Errno, error) {
+	if len(args) < len(argtypes) || (!variadic && len(args) != len(argtypes)) {
+		return reflect.Value{}, 0, &CallError{-1, fmt.Sprintf(
+			"invalid number of arguments. expected '%d', got '%d'.",
+			len(argtypes), len(args))}
+	}
+
+	ft := fn.Type()
+	nfixed := len(argtypes)
+	in := make([]reflect.Value, 0, len(args))
+	frame := new_call_frame(nfixed)
+	defer frame.free()
+	for i, t := range argtypes {
+		err := frame.set(i, t, args[i])
+		if err != nil {
+			return reflect.Value{}, 0, err
+		}
+		in = append(in, goarg_from_c(Value{t, frame.cargs[i], nil}, ft.In(i)))
+	}
+	for i := nfixed; i < len(args); i++ {
+		_, arg, err := ctype_from_vararg(i, args[i])
+		if err != nil {
+			return reflect.Value{}, 0, err
+		}
+		in = append(in, reflect.ValueOf(arg))
+	}
+
+	results := fn.Call(in)
+
+	var errno syscall.Errno
+	if with_errno {
+		errno = results[len(results)-1].Interface().(syscall.Errno)
+	}
+	buf := result_buffer(rtype)
+	if rtype.Kind() != Void {
+		err := cresult_from_go(rtype, buf, results[0])
+		if err != nil {
+			return reflect.Value{}, errno, err
+		}
+	}
+	out := goresult(rtype, buf)
+
+	for _, o := range frame.outs {
+		err := o.p.decode(o.cval)
+		if err != nil {
+			return out, errno, &CallError{o.arg, err.Error()}
+		}
+	}
+	return out, errno, nil
+}
+
+var _ backend = (*mock_backend)(nil)
+
+// EOF
@@ -0,0 +1,149 @@
+package ffi_test
+
+import (
+	"math"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestMockLibrary(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	defer mock.Close()
+
+	err := mock.Define("cos", func(x float64) float64 { return 2 * x })
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = mock.Define("strlen", func(s string) int32 { return int32(len(s)) })
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = mock.Define("frexp", func(x float64, exp unsafe.Pointer) float64 {
+		*(*int32)(exp) = 3
+		return x / 8
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	cos, err := mock.Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 3.0, cos(1.5).Float())
+
+	strlen, err := mock.Fct("strlen", ffi.C_int32, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, int64(5), strlen("hello").Int())
+
+	frexp, err := mock.Fct("frexp", ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	exp := int32(-1)
+	eq(t, 6.0, frexp(48., ffi.Out(&exp)).Float())
+	eq(t, int32(3), exp)
+
+	// arguments are converted to their C type, as for real calls
+	_, err = cos.Call("not a double")
+	if err == nil {
+		t.Errorf("expected an error passing a string as a double")
+	}
+	_, err = cos.Call(1.0, 2.0)
+	if err == nil {
+		t.Errorf("expected an error passing too many arguments")
+	}
+
+	// bindings work as for real libraries
+	var fcos func(float64) float64
+	err = mock.Bind(&fcos, "cos")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 1.0, fcos(0.5))
+}
+
+func TestMockLibraryTypeCheck(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	defer mock.Close()
+
+	err := mock.Define("cos", 42)
+	if err == nil {
+		t.Errorf("expected an error defining a non-func")
+	}
+	mock.Define("cos", func(x float64) float64 { return math.Cos(x) })
+
+	for _, table := range []struct {
+		rtype    ffi.Type
+		argtypes []ffi.Type
+	}{
+		{ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_double}},
+		{ffi.C_double, []ffi.Type{ffi.C_int32}},
+		{ffi.C_pointer, []ffi.Type{ffi.C_double}},
+		{ffi.C_void, []ffi.Type{ffi.C_double}},
+	} {
+		_, err := mock.Fct("cos", table.rtype, table.argtypes)
+		if err == nil {
+			t.Errorf("expected an error for signature %v -> %v", table.argtypes, table.rtype)
+		}
+	}
+
+	_, err = mock.Fct("sin", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err == nil {
+		t.Errorf("expected an error for an undefined symbol")
+	}
+
+	// Values are checked against the result type at each call
+	pair, err := ffi.NewStructType("mock_pair", []ffi.Field{{"a", ffi.C_int64}, {"b", ffi.C_int64}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	mock.Define("pair", func() ffi.Value { return ffi.New(ffi.C_int8) })
+	fpair, err := mock.Fct("pair", pair, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, err = fpair.Call()
+	if _, ok := err.(*ffi.CallError); !ok {
+		t.Errorf("expected a *ffi.CallError returning a Value of another type (got %v)", err)
+	}
+}
+
+func TestMockLibraryErrno(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	mock.Define("close", func(fd int32) (int32, syscall.Errno) {
+		return -1, syscall.EBADF
+	})
+
+	fclose, err := mock.FctErrno("close", ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out, errno := fclose(int32(-1))
+	eq(t, int64(-1), out.Int())
+	eq(t, syscall.EBADF, errno)
+}
+
+func TestMockLibraryVariadic(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	var got []interface{}
+	mock.Define("printf", func(format string, args ...interface{}) int32 {
+		got = args
+		return int32(len(format))
+	})
+
+	printf, err := mock.FctVariadic("printf", ffi.C_int32, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, int64(5), printf("%d %f", int8(4), float32(1.5)).Int())
+	// variadic arguments undergo the default argument promotions
+	eq(t, []interface{}{int32(4), float64(1.5)}, got)
+}
+
+// EOF
@@ -0,0 +1,236 @@
+package ffi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// ErrNotAvailable is wrapped by the errors reporting functions missing from
+// a library, and by the errors of the calls of missing optional functions.
+var ErrNotAvailable = errors.New("ffi: symbol not available")
+
+// not_available_error reports a function missing from a library.
+type not_available_error struct {
+	err error
+}
+
+func (e *not_available_error) Error() string {
+	return e.err.Error()
+}
+
+func (e *not_available_error) Unwrap() []error {
+	return []error{ErrNotAvailable, e.err}
+}
+
+// NamePolicy returns the names to try, in order, to resolve the function
+// name of a library.
+type NamePolicy func(name string) []string
+
+// WithNames returns a copy of lib resolving its functions with the name
+// policy p.
+func (lib Library) WithNames(p NamePolicy) *Library {
+	lib.names = p
+	return &lib
+}
+
+// Suffixed returns a NamePolicy trying the name followed by each of the
+// suffixes, then the name itself: Suffixed("64") tries "foo64", then "foo".
+func Suffixed(suffixes ...string) NamePolicy {
+	return func(name string) []string {
+		names := make([]string, 0, len(suffixes)+1)
+		for _, sfx := range suffixes {
+			names = append(names, name+sfx)
+		}
+		return append(names, name)
+	}
+}
+
+// Prefixed returns a NamePolicy trying the name preceded by each of the
+// prefixes, then the name itself: Prefixed("vendor_") tries "vendor_foo",
+// then "foo".
+func Prefixed(prefixes ...string) NamePolicy {
+	return func(name string) []string {
+		names := make([]string, 0, len(prefixes)+1)
+		for _, pfx := range prefixes {
+			names = append(names, pfx+name)
+		}
+		return append(names, name)
+	}
+}
+
+// Aliases returns a NamePolicy trying the name itself, then its aliases.
+func Aliases(aliases map[string][]string) NamePolicy {
+	return func(name string) []string {
+		return append([]string{name}, aliases[name]...)
+	}
+}
+
+// names_of returns the names to try to resolve fctname.
+func (lib Library) names_of(fctname string) []string {
+	if lib.names == nil {
+		return []string{fctname}
+	}
+	names := lib.names(fctname)
+	if len(names) == 0 {
+		return []string{fctname}
+	}
+	return names
+}
+
+// Optional returns a copy of lib where the functions names are optional: if
+// missing from the library (see Has), Fct and its variants return a stub
+// whose calls fail with an error wrapping ErrNotAvailable, instead of an
+// error.
+// A versioned function ("memcpy@GLIBC_2.2.5", see FctVersion) is optional
+// if either its name or its versioned name is.
+func (lib Library) Optional(names ...string) *Library {
+	// do not share the set with other copies of lib
+	optional := make(map[string]bool, len(lib.optional)+len(names))
+	for name := range lib.optional {
+		optional[name] = true
+	}
+	for _, name := range names {
+		optional[name] = true
+	}
+	lib.optional = optional
+	return &lib
+}
+
+// Has returns whether the library provides the symbol name, or one of its
+// alternative names.
+func (lib Library) Has(name string) bool {
+	if lib.state.is_closed() {
+		return false
+	}
+	for _, n := range lib.names_of(name) {
+		if lib.backend != nil {
+			if lib.backend.has(n) {
+				return true
+			}
+			continue
+		}
+		if _, err := lib.symbol(n); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the address of the function fctname, or of its first
+// alternative name found, together with the name found.
+func (lib Library) lookup(fctname string) (FctPtr, string, error) {
+	if lib.state.is_closed() {
+		return FctPtr{}, fctname, ErrLibraryClosed
+	}
+	var first error
+	for _, name := range lib.names_of(fctname) {
+		sym, err := lib.symbol(name)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		err = check_function(name, sym)
+		if err != nil {
+			return FctPtr{}, name, err
+		}
+		fct, err := NewFctPtr(sym)
+		return fct, name, err
+	}
+	return FctPtr{}, fctname, &not_available_error{lib.symbol_error(fctname, first)}
+}
+
+// backend_function returns the function fctname, or its first alternative
+// name found, of the backend of lib, together with the name found.
+func (lib Library) backend_function(fctname string, rtype Type, argtypes []Type, variadic bool) (ErrnoFunction, string, error) {
+	if lib.state.is_closed() {
+		return nil, fctname, ErrLibraryClosed
+	}
+	for _, name := range lib.names_of(fctname) {
+		if lib.backend.has(name) {
+			fn, err := lib.backend.function(name, rtype, argtypes, variadic)
+			return fn, name, err
+		}
+	}
+	return nil, fctname, &not_available_error{fmt.Errorf("ffi: no function [%s] in library", fctname)}
+}
+
+// is_optional returns whether the function fctname, which may be
+// versioned, is optional.
+func (lib Library) is_optional(fctname string) bool {
+	if lib.optional[fctname] {
+		return true
+	}
+	if i := strings.Index(fctname, "@"); i >= 0 {
+		return lib.optional[fctname[:i]]
+	}
+	return false
+}
+
+// unavailable returns the stub of the function fctname if it is optional and
+// err reports it missing, or err.
+func (lib Library) unavailable(fctname string, err error) (Function, error) {
+	if !lib.is_optional(fctname) || !errors.Is(err, ErrNotAvailable) {
+		return nil_fct, err
+	}
+	cerr := &not_available_error{fmt.Errorf("ffi: optional function [%s] is not available: %v", fctname, err)}
+	fct := func(args ...interface{}) reflect.Value {
+		panic(cerr)
+	}
+	return lib.wrap(Function(fct), fctname, nil, nil), nil
+}
+
+// unavailable_errno is like unavailable, for ErrnoFunctions.
+func (lib Library) unavailable_errno(fctname string, err error) (ErrnoFunction, error) {
+	fct, err := lib.unavailable(fctname, err)
+	if err != nil {
+		return nil, err
+	}
+	return func(args ...interface{}) (reflect.Value, syscall.Errno) {
+		return fct(args...), 0
+	}, nil
+}
+
+// FctVersion returns a Function calling the given version of the function
+// fctname, e.g. "memcpy" at version "GLIBC_2.2.5", with the default abi of
+// the platform.
+// The functions of mock libraries are looked up as "fctname@version".
+func (lib Library) FctVersion(fctname, version string, rtype Type, argtypes []Type) (Function, error) {
+	symbol := fctname + "@" + version
+	if lib.state.is_closed() {
+		return nil_fct, ErrLibraryClosed
+	}
+	if lib.backend != nil {
+		if !lib.backend.has(symbol) {
+			return lib.unavailable(symbol, &not_available_error{fmt.Errorf("ffi: no function [%s] in library", symbol)})
+		}
+		fn, err := lib.backend.function(symbol, rtype, argtypes, false)
+		if err != nil {
+			return nil_fct, err
+		}
+		return lib.wrap(fn.function(), symbol, rtype, argtypes), nil
+	}
+	sym, err := lib.dlvsym(fctname, version)
+	if err != nil {
+		return lib.unavailable(symbol, &not_available_error{err})
+	}
+	err = check_function(symbol, sym)
+	if err != nil {
+		return nil_fct, err
+	}
+	fct, err := NewFctPtr(sym)
+	if err != nil {
+		return nil_fct, err
+	}
+	fn, err := make_function(fct, DefaultAbi, rtype, argtypes)
+	if err != nil {
+		return nil_fct, err
+	}
+	return lib.wrap(fn, symbol, rtype, argtypes), nil
+}
+
+// EOF
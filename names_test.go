@@ -0,0 +1,156 @@
+package ffi_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestFctVersion(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("symbol versions of ELF shared libraries only")
+	}
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	syms, err := lib.Symbols()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	version := ""
+	for _, s := range syms {
+		if s.Name == "strlen" {
+			version = s.Version
+		}
+	}
+	if version == "" {
+		t.Fatalf("no version for [strlen]")
+	}
+
+	strlen, err := lib.FctVersion("strlen", version, ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, uint64(5), strlen("hello").Uint())
+
+	strlen, err = ffi.Default().FctVersion("strlen", version, ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, uint64(3), strlen("abc").Uint())
+
+	_, err = lib.FctVersion("strlen", "NO_SUCH_VERSION", ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+	if !errors.Is(err, ffi.ErrNotAvailable) {
+		t.Errorf("expected ErrNotAvailable (got %v)", err)
+	}
+}
+
+func TestNamePolicy(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	mock.Define("foo64", func(x int64) int64 { return 64 * x })
+	mock.Define("foo", func(x int64) int64 { return x })
+	mock.Define("vendor_bar", func(x int64) int64 { return -x })
+	mock.Define("baz_v2", func(x int64) int64 { return 2 * x })
+
+	for _, table := range []struct {
+		policy ffi.NamePolicy
+		name   string
+		ref    int64
+	}{
+		{nil, "foo", 3},
+		{ffi.Suffixed("64"), "foo", 192},
+		{ffi.Suffixed("_v3", "_v2"), "baz", 6},
+		{ffi.Prefixed("vendor_"), "bar", -3},
+		{ffi.Aliases(map[string][]string{"qux": {"foo"}}), "qux", 3},
+	} {
+		lib := mock.WithNames(table.policy)
+		if !lib.Has(table.name) {
+			t.Errorf("expected [%s] to be available", table.name)
+		}
+		fct, err := lib.Fct(table.name, ffi.C_int64, []ffi.Type{ffi.C_int64})
+		if err != nil {
+			t.Errorf("%s: %v", table.name, err)
+			continue
+		}
+		eq(t, table.ref, fct(int64(3)).Int())
+	}
+
+	if mock.Has("bar") {
+		t.Errorf("expected [bar] not to be available without a name policy")
+	}
+}
+
+func TestOptionalFct(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	lib = lib.WithNames(ffi.Suffixed("64"))
+
+	if !lib.Has("fopen") {
+		t.Errorf("expected [fopen] to be available")
+	}
+	if lib.Has("no_such_ffi_function") {
+		t.Errorf("expected [no_such_ffi_function] not to be available")
+	}
+
+	_, err = lib.Fct("no_such_ffi_function", ffi.C_int32, nil)
+	if !errors.Is(err, ffi.ErrNotAvailable) {
+		t.Errorf("expected ErrNotAvailable (got %v)", err)
+	}
+
+	lib = lib.Optional("no_such_ffi_function")
+	fct, err := lib.Fct("no_such_ffi_function", ffi.C_int32, nil)
+	if err != nil {
+		t.Fatalf("expected a stub for an optional function (got %v)", err)
+	}
+	_, err = fct.Call()
+	if !errors.Is(err, ffi.ErrNotAvailable) {
+		t.Errorf("expected ErrNotAvailable calling a missing optional function (got %v)", err)
+	}
+	efct, err := lib.FctErrno("no_such_ffi_function", ffi.C_int32, nil)
+	if err != nil {
+		t.Fatalf("expected a stub for an optional function (got %v)", err)
+	}
+	_, _, err = efct.Call()
+	if !errors.Is(err, ffi.ErrNotAvailable) {
+		t.Errorf("expected ErrNotAvailable calling a missing optional function (got %v)", err)
+	}
+
+	// available optional functions are resolved as usual
+	lib = lib.Optional("strlen")
+	strlen, err := lib.Fct("strlen", ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, uint64(3), strlen("abc").Uint())
+}
+
+func TestMockFctVersion(t *testing.T) {
+	mock := ffi.NewMockLibrary()
+	mock.Define("memcpy@V2", func(x int32) int32 { return 2 })
+	lib := mock.Optional("memcpy")
+
+	fct, err := lib.FctVersion("memcpy", "V2", ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, int64(2), fct(int32(0)).Int())
+
+	fct, err = lib.FctVersion("memcpy", "V3", ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("expected a stub for an optional function (got %v)", err)
+	}
+	_, err = fct.Call(int32(0))
+	if !errors.Is(err, ffi.ErrNotAvailable) {
+		t.Errorf("expected ErrNotAvailable (got %v)", err)
+	}
+}
+
+// EOF
@@ -0,0 +1,59 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// OutParam is a pointer argument whose pointee is written by the C function.
+// The go value is converted into a C-layout Value, the address of which is
+// passed to the function, and converted back into the go value after the
+// call.
+// OutParams are created with Out and InOut.
+type OutParam struct {
+	ptr   interface{}
+	inout bool
+}
+
+// Out marks ptr, a pointer to a go value, as an output parameter.
+// The C function receives a pointer to a zeroed C-layout copy of *ptr.
+func Out(ptr interface{}) OutParam {
+	return OutParam{ptr: ptr, inout: false}
+}
+
+// InOut marks ptr, a pointer to a go value, as an input/output parameter.
+// The C function receives a pointer to a C-layout copy of *ptr.
+func InOut(ptr interface{}) OutParam {
+	return OutParam{ptr: ptr, inout: true}
+}
+
+// encode returns the C-layout Value the C function will operate on.
+func (p OutParam) encode() (cval Value, err error) {
+	rv := reflect.ValueOf(p.ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return Value{}, fmt.Errorf("ffi.OutParam: expected a non-nil pointer (got %T)", p.ptr)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ffi.OutParam: %v", r)
+		}
+	}()
+	ct := ctype_from_gotype(rv.Type().Elem())
+	cval = New(ct)
+	if p.inout {
+		err = NewEncoder(cval).Encode(rv.Elem().Interface())
+	}
+	return cval, err
+}
+
+// decode writes back the C-layout Value into the go value.
+func (p OutParam) decode(cval Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ffi.OutParam: %v", r)
+		}
+	}()
+	return NewDecoder(cval).Decode(p.ptr)
+}
+
+// EOF
@@ -0,0 +1,119 @@
+package ffi_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestOutParam(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//double frexp(double x, int *exp);
+	frexp, err := lib.Fct("frexp", ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [frexp]: %v", err)
+	}
+
+	exp := int32(-1)
+	frac := frexp(48., ffi.Out(&exp)).Float()
+	ref_frac, ref_exp := math.Frexp(48.)
+	eq(t, ref_frac, frac)
+	eq(t, int32(ref_exp), exp)
+
+	_, err = frexp.Call(48., ffi.Out(exp))
+	if err == nil {
+		t.Errorf("expected an error passing a non-pointer as output parameter")
+	}
+}
+
+func TestOutParamStruct(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	type timespec struct {
+		Sec  int64
+		Nsec int64
+	}
+
+	//int clock_getres(clockid_t clk_id, struct timespec *res);
+	clock_getres, err := lib.Fct("clock_getres", ffi.C_int32, []ffi.Type{ffi.C_int32, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [clock_getres]: %v", err)
+	}
+
+	res := timespec{Sec: -1, Nsec: -1}
+	const CLOCK_REALTIME = 0
+	out := clock_getres(int32(CLOCK_REALTIME), ffi.Out(&res)).Int()
+	eq(t, int64(0), out)
+	if res.Sec < 0 || res.Nsec < 0 || (res.Sec == 0 && res.Nsec == 0) {
+		t.Errorf("invalid clock resolution: %+v", res)
+	}
+}
+
+func TestInOutParam(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//long nrand48(unsigned short xsubi[3]);
+	nrand48, err := lib.Fct("nrand48", ffi.C_int64, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [nrand48]: %v", err)
+	}
+
+	seed := [3]uint16{1, 2, 3}
+	xsubi := seed
+	r1 := nrand48(ffi.InOut(&xsubi)).Int()
+	if xsubi == seed {
+		t.Errorf("expected nrand48 to update its state")
+	}
+
+	// restarting from the same seed yields the same sequence
+	xsubi2 := seed
+	r2 := nrand48(ffi.InOut(&xsubi2)).Int()
+	eq(t, r1, r2)
+	eq(t, xsubi, xsubi2)
+
+	// an Out parameter starts from a zeroed state
+	xsubi3 := seed
+	zero := [3]uint16{}
+	r3 := nrand48(ffi.Out(&xsubi3)).Int()
+	r0 := nrand48(ffi.InOut(&zero)).Int()
+	eq(t, r0, r3)
+}
+
+func TestOutParamVariadic(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//int sscanf(const char *str, const char *format, ...);
+	sscanf, err := lib.FctVariadic("sscanf", ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [sscanf]: %v", err)
+	}
+
+	var (
+		i int32
+		f float64
+	)
+	n := sscanf("42 -2.5", "%d %lf", ffi.Out(&i), ffi.Out(&f)).Int()
+	eq(t, int64(2), n)
+	eq(t, int32(42), i)
+	eq(t, -2.5, f)
+}
+
+// EOF
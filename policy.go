@@ -0,0 +1,150 @@
+package ffi
+
+// #include <pthread.h>
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// Policy controls from where, and how concurrently, C functions are called.
+// Do runs f, which performs a single C call, according to the policy.
+type Policy interface {
+	Do(f func())
+}
+
+// FreePolicy calls C functions directly from the calling goroutine, without
+// any synchronization. It is the default policy.
+var FreePolicy Policy = free_policy{}
+
+type free_policy struct{}
+
+func (free_policy) Do(f func()) {
+	f()
+}
+
+// NewSerializedPolicy returns a Policy serializing the calls with a mutex,
+// for libraries which are not thread-safe.
+// A C function called under the policy must not call back into go code
+// calling functions under the same policy.
+func NewSerializedPolicy() Policy {
+	return &serialized_policy{}
+}
+
+type serialized_policy struct {
+	mu sync.Mutex
+}
+
+func (p *serialized_policy) Do(f func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f()
+}
+
+// ThreadPolicy dispatches all the calls onto a dedicated goroutine, locked
+// to its OS thread, for thread-affine libraries.
+// Calls made from C callbacks running on that thread are performed
+// directly.
+type ThreadPolicy struct {
+	calls  chan thread_call
+	done   chan struct{}
+	once   sync.Once
+	thread C.pthread_t
+}
+
+// thread_call is a call dispatched onto the thread of a ThreadPolicy
+type thread_call struct {
+	f   func()
+	res chan interface{} // the value f panicked with, if any
+}
+
+// NewThreadPolicy starts the worker goroutine of a new ThreadPolicy.
+// The worker is stopped with Close.
+func NewThreadPolicy() *ThreadPolicy {
+	p := &ThreadPolicy{
+		calls: make(chan thread_call),
+		done:  make(chan struct{}),
+	}
+	started := make(chan struct{})
+	go p.run(started)
+	<-started
+	return p
+}
+
+func (p *ThreadPolicy) run(started chan struct{}) {
+	runtime.LockOSThread()
+	// the thread is not handed back to the go scheduler: it may hold C
+	// thread-local state.
+	p.thread = C.pthread_self()
+	close(started)
+	for {
+		select {
+		case c := <-p.calls:
+			c.res <- thread_run(c.f)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// thread_run runs f, returning the value it panicked with.
+func thread_run(f func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+	f()
+	return nil
+}
+
+// Do runs f on the thread of the policy.
+// Do panics if the policy has been closed.
+func (p *ThreadPolicy) Do(f func()) {
+	if C.pthread_equal(C.pthread_self(), p.thread) != 0 {
+		// re-entrant call, from a callback
+		f()
+		return
+	}
+	c := thread_call{f: f, res: make(chan interface{}, 1)}
+	select {
+	case p.calls <- c:
+	case <-p.done:
+		panic(fmt.Errorf("ffi: call through a closed ThreadPolicy"))
+	}
+	if r := <-c.res; r != nil {
+		panic(r)
+	}
+}
+
+// Close stops the worker goroutine of the policy.
+func (p *ThreadPolicy) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// WithPolicy returns a Function calling fct according to the policy p.
+func (fct Function) WithPolicy(p Policy) Function {
+	if p == nil {
+		return fct
+	}
+	return func(args ...interface{}) (out reflect.Value) {
+		p.Do(func() { out = fct(args...) })
+		return out
+	}
+}
+
+// WithPolicy returns an ErrnoFunction calling fct according to the policy p.
+func (fct ErrnoFunction) WithPolicy(p Policy) ErrnoFunction {
+	if p == nil {
+		return fct
+	}
+	return func(args ...interface{}) (out reflect.Value, errno syscall.Errno) {
+		p.Do(func() { out, errno = fct(args...) })
+		return out, errno
+	}
+}
+
+// EOF
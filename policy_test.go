@@ -0,0 +1,138 @@
+package ffi_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestThreadPolicy(t *testing.T) {
+	tp := ffi.NewThreadPolicy()
+	defer tp.Close()
+
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	tlib := lib.WithPolicy(tp)
+
+	//pthread_t pthread_self(void);
+	self, err := tlib.Fct("pthread_self", ffi.C_uint64, nil)
+	if err != nil {
+		t.Fatalf("could not locate function [pthread_self]: %v", err)
+	}
+
+	ref := self().Uint()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if tid := self().Uint(); tid != ref {
+					t.Errorf("call on thread %x, expected %x", tid, ref)
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// calls from callbacks running on the thread of the policy do not
+	// deadlock.
+	qsort, err := tlib.Fct("qsort", ffi.C_void,
+		[]ffi.Type{ffi.C_pointer, ffi.C_uint64, ffi.C_uint64, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [qsort]: %v", err)
+	}
+	cmp, err := ffi.NewCallback(
+		func(a, b unsafe.Pointer) int32 {
+			if tid := self().Uint(); tid != ref {
+				t.Errorf("callback on thread %x, expected %x", tid, ref)
+			}
+			return *(*int32)(a) - *(*int32)(b)
+		},
+		ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_pointer},
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cmp.Free()
+	data := []int32{3, 1, 2}
+	qsort(unsafe.Pointer(&data[0]), uint64(len(data)), uint64(4), cmp.FctPtr())
+	eq(t, []int32{1, 2, 3}, data)
+	if err := cmp.Err(); err != nil {
+		t.Errorf("unexpected callback error: %v", err)
+	}
+
+	// errors are reported to the caller
+	_, err = self.Call(42)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+
+	tp.Close()
+	_, err = self.Call()
+	if err == nil {
+		t.Errorf("expected an error after Close")
+	}
+}
+
+func TestSerializedPolicy(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		inside  bool
+		overlap bool
+	)
+	cb, err := ffi.NewCallback(
+		func() {
+			mu.Lock()
+			if inside {
+				overlap = true
+			}
+			inside = true
+			mu.Unlock()
+
+			runtime.Gosched()
+
+			mu.Lock()
+			inside = false
+			mu.Unlock()
+		},
+		ffi.C_void, nil,
+	)
+	if err != nil {
+		t.Fatalf("could not create callback: %v", err)
+	}
+	defer cb.Free()
+
+	fct, err := ffi.MakeFunction(cb.FctPtr(), ffi.C_void, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fct = fct.WithPolicy(ffi.NewSerializedPolicy())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				fct()
+			}
+		}()
+	}
+	wg.Wait()
+	if overlap {
+		t.Errorf("concurrent calls under a serialized policy")
+	}
+	if err := cb.Err(); err != nil {
+		t.Errorf("unexpected callback error: %v", err)
+	}
+}
+
+// EOF
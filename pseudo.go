@@ -0,0 +1,133 @@
+package ffi
+
+// #define _GNU_SOURCE
+// #include <dlfcn.h>
+// #include <stdlib.h>
+// #include <string.h>
+//
+// enum {
+//   _GO_FFI_PSEUDO_SELF = 1,
+//   _GO_FFI_PSEUDO_DEFAULT = 2,
+//   _GO_FFI_PSEUDO_NEXT = 3,
+// };
+//
+// static void *_go_ffi_pseudo_handle(int kind)
+// {
+//   switch (kind) {
+//   case _GO_FFI_PSEUDO_SELF:
+// #ifdef RTLD_SELF
+//     return RTLD_SELF;
+// #else
+//     return dlopen(NULL, RTLD_LAZY);
+// #endif
+//   case _GO_FFI_PSEUDO_DEFAULT:
+//     return RTLD_DEFAULT;
+//   case _GO_FFI_PSEUDO_NEXT:
+//     return RTLD_NEXT;
+//   }
+//   return NULL;
+// }
+//
+// // _go_ffi_dlsym returns the address of the symbol name of the handle h,
+// // or sets err to an error message to be freed.
+// static void *_go_ffi_dlsym(void *h, const char *name, char **err)
+// {
+//   const char *msg = NULL;
+//   void *sym = NULL;
+//   dlerror();
+//   sym = dlsym(h, name);
+//   msg = dlerror();
+//   if (msg != NULL) {
+//     *err = strdup(msg);
+//   }
+//   return sym;
+// }
+import "C"
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// pseudo_kind identifies the pseudo-library of a Library, if any.
+type pseudo_kind int
+
+const (
+	pseudo_none    pseudo_kind = 0
+	pseudo_self    pseudo_kind = C._GO_FFI_PSEUDO_SELF
+	pseudo_default pseudo_kind = C._GO_FFI_PSEUDO_DEFAULT
+	pseudo_next    pseudo_kind = C._GO_FFI_PSEUDO_NEXT
+)
+
+// g_self holds the handle of the main program
+var g_self = struct {
+	once   sync.Once
+	handle unsafe.Pointer
+}{}
+
+// handle returns the special dlsym handle of the pseudo-library k.
+func (k pseudo_kind) handle() unsafe.Pointer {
+	if k == pseudo_self {
+		g_self.once.Do(func() {
+			g_self.handle = C._go_ffi_pseudo_handle(C.int(k))
+		})
+		return g_self.handle
+	}
+	return C._go_ffi_pseudo_handle(C.int(k))
+}
+
+// Self returns a Library resolving the symbols of the main program and of
+// the libraries it was linked against (e.g. libc and the cgo dependencies of
+// a go program).
+// Where the platform has no RTLD_SELF (e.g. glibc), the symbols are resolved
+// through the handle of the main program, whose search order is the global
+// scope: Self then also finds the symbols of the libraries loaded with
+// RtldGlobal, as Default does.
+func Self() *Library {
+	path, _ := os.Executable()
+	return &Library{pseudo: pseudo_self, path: path, state: new_lib_state(nil)}
+}
+
+// Default returns a Library resolving symbols in the default search order
+// of the dynamic linker (RTLD_DEFAULT): the main program, its dependencies,
+// then the libraries loaded with RtldGlobal.
+func Default() *Library {
+	return &Library{pseudo: pseudo_default, state: new_lib_state(nil)}
+}
+
+// Next returns a Library resolving the next definitions of symbols after the
+// object calling dlsym (RTLD_NEXT): ffi resolves symbols from the go program
+// itself, so these are the definitions following those of the go program in
+// the search order, e.g. for a go program interposing C functions to call
+// the definitions it interposes.
+func Next() *Library {
+	return &Library{pseudo: pseudo_next, state: new_lib_state(nil)}
+}
+
+// symbol returns the address of the symbol name of lib.
+func (lib Library) symbol(name string) (unsafe.Pointer, error) {
+	if lib.state.is_closed() {
+		return nil, ErrLibraryClosed
+	}
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	var c_err *C.char
+	sym := C._go_ffi_dlsym(lib.dlsym_handle(), c_name, &c_err)
+	if c_err != nil {
+		defer C.free(unsafe.Pointer(c_err))
+		return nil, errors.New(C.GoString(c_err))
+	}
+	return sym, nil
+}
+
+// dlsym_handle returns the handle to resolve the symbols of lib with.
+func (lib Library) dlsym_handle() unsafe.Pointer {
+	if lib.pseudo != pseudo_none {
+		return lib.pseudo.handle()
+	}
+	return lib.handle.c
+}
+
+// EOF
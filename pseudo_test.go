@@ -0,0 +1,57 @@
+package ffi_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestPseudoLibraries(t *testing.T) {
+	for _, table := range []struct {
+		name string
+		lib  *ffi.Library
+	}{
+		{"self", ffi.Self()},
+		{"default", ffi.Default()},
+		{"next", ffi.Next()},
+	} {
+		lib := table.lib
+		// libc is linked into every cgo program
+		strlen, err := lib.Fct("strlen", ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+		if err != nil {
+			t.Errorf("%s: %v", table.name, err)
+			continue
+		}
+		eq(t, uint64(5), strlen("hello").Uint())
+
+		if !lib.Has("strlen") {
+			t.Errorf("%s: expected [strlen] to be available", table.name)
+		}
+		_, err = lib.Fct("no_such_ffi_function", ffi.C_int32, nil)
+		if !errors.Is(err, ffi.ErrNotAvailable) {
+			t.Errorf("%s: expected ErrNotAvailable (got %v)", table.name, err)
+		}
+		err = lib.Close()
+		if err != nil {
+			t.Errorf("%s: closing a pseudo-library: %v", table.name, err)
+		}
+	}
+}
+
+func TestDefaultGlobalLibrary(t *testing.T) {
+	lib, err := ffi.NewLibraryWithFlags(libm_name, ffi.RtldGlobal)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	// symbols of RtldGlobal libraries are in the default search order
+	cos, err := ffi.Default().Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 1.0, cos(0.0).Float())
+}
+
+// EOF
@@ -0,0 +1,614 @@
+package ffi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// record_entry is a recorded call, as serialized by a Recorder.
+//
+// Arguments and results are encoded after their C type: integers and
+// floats as numbers (non-finite floats as strings), structs and arrays as
+// lists. Pointer arguments are encoded as {"string": ...}, {"bytes": ...},
+// {"value": ...}, {"inout": ...}, {"out": null}, {"mem": ...} for go pointers
+// and slices, null, or {"ptr": null} for opaque pointers (uintptr,
+// unsafe.Pointer, functions), whose contents are not recorded.
+type record_entry struct {
+	Symbol string                  `json:"symbol"`
+	Args   []json.RawMessage       `json:"args"`
+	Result json.RawMessage         `json:"result,omitempty"`
+	Outs   map[int]json.RawMessage `json:"outs,omitempty"` // buffer contents after the call, by argument index
+	Errno  syscall.Errno           `json:"errno,omitempty"`
+}
+
+// Recorder records the calls performed through its Interceptor, as lines
+// of JSON which NewReplayLibrary can answer calls from.
+// Only the calls of functions created from a Library (whose types are
+// known) and which did not fail are recorded.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error met while recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Interceptor returns the Interceptor recording the calls.
+func (r *Recorder) Interceptor() Interceptor {
+	return func(ci *CallInfo, next Invoker) (reflect.Value, error) {
+		if ci.RType == nil {
+			r.fail(fmt.Errorf("ffi.Recorder: no type information for [%s]", ci.Symbol))
+			return next(ci)
+		}
+		types, args, err := call_types(ci.ArgTypes, ci.Args, len(ci.Args) > len(ci.ArgTypes))
+		if err != nil {
+			// the call will fail as well
+			return next(ci)
+		}
+		entry := record_entry{Symbol: ci.Symbol, Args: make([]json.RawMessage, len(args))}
+		for i, arg := range args {
+			entry.Args[i], err = arg_json(i, types[i], arg)
+			if err != nil {
+				return next(ci)
+			}
+		}
+
+		out, cerr := next(ci)
+		if cerr != nil {
+			return out, cerr
+		}
+		entry.Errno = ci.Errno
+		entry.Result, err = result_json(ci.RType, out)
+		if err == nil {
+			entry.Outs, err = outs_json(args)
+		}
+		if err == nil {
+			r.mu.Lock()
+			err = r.enc.Encode(entry)
+			r.mu.Unlock()
+		}
+		if err != nil {
+			r.fail(fmt.Errorf("ffi.Recorder: could not record call to [%s]: %v", ci.Symbol, err))
+		}
+		return out, cerr
+	}
+}
+
+// call_types returns the types of the arguments of a call, and the
+// arguments as passed to the C function.
+// The types of the variadic arguments are inferred from their go values.
+func call_types(fixed []Type, args []interface{}, variadic bool) ([]Type, []interface{}, error) {
+	if len(args) < len(fixed) || (!variadic && len(args) != len(fixed)) {
+		return nil, nil, &CallError{-1, fmt.Sprintf(
+			"invalid number of arguments. expected '%d', got '%d'.",
+			len(fixed), len(args))}
+	}
+	types := make([]Type, len(args))
+	copy(types, fixed)
+	cargs := make([]interface{}, len(args))
+	copy(cargs, args)
+	for i := len(fixed); i < len(args); i++ {
+		var err error
+		types[i], cargs[i], err = ctype_from_vararg(i, args[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return types, cargs, nil
+}
+
+// arg_json encodes arg, the i-th argument of a call, of type t.
+func arg_json(i int, t Type, arg interface{}) (json.RawMessage, error) {
+	switch t.Kind() {
+	case Ptr, Array:
+		var data interface{} = map[string]interface{}{"ptr": nil}
+		switch v := arg.(type) {
+		case nil:
+			data = nil
+		case string:
+			data = map[string]interface{}{"string": v}
+		case []byte:
+			data = map[string]interface{}{"bytes": v}
+		case OutParam:
+			data = map[string]interface{}{"out": nil}
+			if v.inout {
+				cval, err := v.encode()
+				if err != nil {
+					return nil, err
+				}
+				data = map[string]interface{}{"inout": value_json(cval)}
+			}
+		case Value:
+			if v.IsValid() && v.Kind() != Ptr {
+				data = map[string]interface{}{"value": value_json(v)}
+			}
+		default:
+			if mem, ok := go_memory(arg); ok {
+				data = map[string]interface{}{"mem": mem}
+			}
+		}
+		return json.Marshal(data)
+	}
+
+	frame := new_call_frame(i + 1)
+	defer frame.free()
+	err := frame.set(i, t, arg)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value_json(Value{t, frame.cargs[i], nil}))
+}
+
+// outs_json encodes the contents of the buffer arguments, after the call.
+func outs_json(args []interface{}) (map[int]json.RawMessage, error) {
+	var outs map[int]json.RawMessage
+	for i, arg := range args {
+		var data interface{}
+		switch v := arg.(type) {
+		case []byte:
+			data = v
+		case OutParam:
+			cval, err := OutParam{v.ptr, true}.encode()
+			if err != nil {
+				return nil, err
+			}
+			data = value_json(cval)
+		case Value:
+			if !v.IsValid() || v.Kind() == Ptr {
+				continue
+			}
+			data = value_json(v)
+		default:
+			mem, ok := go_memory(arg)
+			if !ok {
+				continue
+			}
+			data = mem
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if outs == nil {
+			outs = make(map[int]json.RawMessage)
+		}
+		outs[i] = raw
+	}
+	return outs, nil
+}
+
+// go_memory returns the memory C reads and writes through arg, a non-nil go
+// pointer or a non-empty go slice.
+func go_memory(arg interface{}) ([]byte, bool) {
+	if _, ok := arg.(*Callback); ok {
+		return nil, false
+	}
+	rv := reflect.ValueOf(arg)
+	var n uintptr
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, false
+		}
+		n = rv.Type().Elem().Size()
+	case reflect.Slice:
+		n = uintptr(rv.Len()) * rv.Type().Elem().Size()
+	}
+	if n == 0 {
+		return nil, false
+	}
+	return unsafe.Slice((*byte)(rv.UnsafePointer()), n), true
+}
+
+// result_json encodes out, the result of type t of a call.
+func result_json(t Type, out reflect.Value) (json.RawMessage, error) {
+	if t.Kind() == Void || !out.IsValid() {
+		return nil, nil
+	}
+	var data interface{}
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		data = out.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		data = out.Uint()
+	case reflect.Float32, reflect.Float64:
+		data = float_json(out.Float())
+	default:
+		v, ok := out.Interface().(Value)
+		if !ok {
+			return nil, fmt.Errorf("unsupported result type %s", out.Type())
+		}
+		data = value_json(v)
+	}
+	return json.Marshal(data)
+}
+
+// value_json returns the JSON-encodable representation of v.
+func value_json(v Value) interface{} {
+	switch v.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		return v.Int()
+	case Uint8, Uint16, Uint32, Uint64:
+		return v.Uint()
+	case Float, Double:
+		return float_json(v.Float())
+	case Ptr:
+		return uint64(*(*uintptr)(v.val))
+	case Struct:
+		fields := make([]interface{}, v.NumField())
+		for i := range fields {
+			fields[i] = value_json(v.Field(i))
+		}
+		return fields
+	case Array, Slice:
+		elems := make([]interface{}, v.Len())
+		for i := range elems {
+			elems[i] = value_json(v.Index(i))
+		}
+		return elems
+	}
+	return nil
+}
+
+// float_json encodes x as a number, or as a string if it is not finite.
+func float_json(x float64) interface{} {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return x
+}
+
+// decode_json decodes raw, keeping numbers as json.Number.
+func decode_json(raw json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var data interface{}
+	err := dec.Decode(&data)
+	return data, err
+}
+
+// json_number returns the text of data, a number or a non-finite float.
+func json_number(data interface{}) (string, error) {
+	switch x := data.(type) {
+	case json.Number:
+		return string(x), nil
+	case string:
+		return x, nil
+	}
+	return "", fmt.Errorf("expected a number, got %v", data)
+}
+
+// value_set_json sets v from data, as encoded by value_json.
+func value_set_json(v Value, data interface{}) error {
+	switch v.Kind() {
+	case Int, Int8, Int16, Int32, Int64:
+		s, err := json_number(data)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case Uint8, Uint16, Uint32, Uint64, Ptr:
+		s, err := json_number(data)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		if v.Kind() == Ptr {
+			*(*uintptr)(v.val) = uintptr(n)
+		} else {
+			v.SetUint(n)
+		}
+	case Float, Double:
+		s, err := json_number(data)
+		if err != nil {
+			return err
+		}
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	case Struct, Array, Slice:
+		elems, ok := data.([]interface{})
+		n := 0
+		if v.Kind() == Struct {
+			n = v.NumField()
+		} else {
+			n = v.Len()
+		}
+		if !ok || len(elems) != n {
+			return fmt.Errorf("expected a list of %d values for [%s], got %v", n, v.Type().Name(), data)
+		}
+		for i, elem := range elems {
+			var err error
+			if v.Kind() == Struct {
+				err = value_set_json(v.Field(i), elem)
+			} else {
+				err = value_set_json(v.Index(i), elem)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type [%s]", v.Type().Name())
+	}
+	return nil
+}
+
+// replay_backend answers calls from a recording
+type replay_backend struct {
+	mu      sync.Mutex
+	entries []*replay_entry
+}
+
+type replay_entry struct {
+	record_entry
+	used bool
+}
+
+// NewReplayLibrary returns a Library answering calls from the recording of
+// a Recorder, read from r, without loading the recorded library.
+// A call is answered by the first not yet replayed recorded call with the
+// same symbol and arguments, or else by the last such call: out buffers and
+// the memory of go pointers and slices are filled, and the recorded result
+// returned. The memory behind opaque pointers (uintptr, unsafe.Pointer) is
+// not replayed.
+// Calls which were not recorded panic with a *CallError.
+func NewReplayLibrary(r io.Reader) (*Library, error) {
+	b := &replay_backend{}
+	dec := json.NewDecoder(r)
+	for {
+		var entry replay_entry
+		err := dec.Decode(&entry.record_entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ffi.NewReplayLibrary: invalid recording: %v", err)
+		}
+		for i, arg := range entry.Args {
+			entry.Args[i], err = compact_json(arg)
+			if err != nil {
+				return nil, fmt.Errorf("ffi.NewReplayLibrary: invalid recording: %v", err)
+			}
+		}
+		b.entries = append(b.entries, &entry)
+	}
+	return &Library{backend: b, state: new_lib_state(b.close)}, nil
+}
+
+func compact_json(raw json.RawMessage) (json.RawMessage, error) {
+	buf := new(bytes.Buffer)
+	err := json.Compact(buf, raw)
+	return buf.Bytes(), err
+}
+
+func (b *replay_backend) close() error {
+	return nil
+}
+
+func (b *replay_backend) has(fctname string) bool {
+	for _, entry := range b.entries {
+		if entry.Symbol == fctname {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *replay_backend) function(fctname string, rtype Type, argtypes []Type, variadic bool) (ErrnoFunction, error) {
+	if !b.has(fctname) {
+		return nil, fmt.Errorf("ffi: no recorded call to [%s]", fctname)
+	}
+	fct := func(args ...interface{}) (reflect.Value, syscall.Errno) {
+		out, errno, err := b.call(fctname, rtype, argtypes, variadic, args)
+		if err != nil {
+			panic(err)
+		}
+		return out, errno
+	}
+	return ErrnoFunction(fct), nil
+}
+
+// call answers the call to fctname from the recording.
+func (b *replay_backend) call(fctname string, rtype Type, argtypes []Type, variadic bool, args []interface{}) (reflect.Value, syscall.Errno, error) {
+	types, cargs, err := call_types(argtypes, args, variadic)
+	if err != nil {
+		return reflect.Value{}, 0, err
+	}
+	keys := make([]json.RawMessage, len(cargs))
+	for i, arg := range cargs {
+		keys[i], err = arg_json(i, types[i], arg)
+		if err != nil {
+			if _, ok := err.(*CallError); ok {
+				return reflect.Value{}, 0, err
+			}
+			return reflect.Value{}, 0, &CallError{i, err.Error()}
+		}
+	}
+
+	entry := b.lookup(fctname, keys)
+	if entry == nil {
+		return reflect.Value{}, 0, &CallError{-1, fmt.Sprintf(
+			"no recorded call to [%s] with arguments %s", fctname, keys)}
+	}
+
+	for i, raw := range entry.Outs {
+		if i < 0 || i >= len(cargs) {
+			return reflect.Value{}, 0, &CallError{-1, fmt.Sprintf("invalid recorded call to [%s]", fctname)}
+		}
+		err = replay_out(cargs[i], raw)
+		if err != nil {
+			return reflect.Value{}, 0, &CallError{i, err.Error()}
+		}
+	}
+
+	out, err := replay_result(rtype, entry.Result)
+	if err != nil {
+		return reflect.Value{}, 0, &CallError{-1, fmt.Sprintf("invalid recorded result for [%s]: %v", fctname, err)}
+	}
+	return out, entry.Errno, nil
+}
+
+// lookup returns the recorded call answering the call of fctname with the
+// encoded arguments keys.
+func (b *replay_backend) lookup(fctname string, keys []json.RawMessage) *replay_entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var last *replay_entry
+	for _, entry := range b.entries {
+		if entry.Symbol != fctname || len(entry.Args) != len(keys) {
+			continue
+		}
+		match := true
+		for i := range keys {
+			if !bytes.Equal(entry.Args[i], keys[i]) {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if !entry.used {
+			entry.used = true
+			return entry
+		}
+		last = entry
+	}
+	return last
+}
+
+// replay_out writes the recorded contents raw into the buffer argument arg.
+func replay_out(arg interface{}, raw json.RawMessage) error {
+	switch v := arg.(type) {
+	case []byte:
+		var data []byte
+		err := json.Unmarshal(raw, &data)
+		if err != nil {
+			return err
+		}
+		copy(v, data)
+		return nil
+	case OutParam:
+		data, err := decode_json(raw)
+		if err != nil {
+			return err
+		}
+		cval, err := v.encode()
+		if err != nil {
+			return err
+		}
+		err = value_set_json(cval, data)
+		if err != nil {
+			return err
+		}
+		return v.decode(cval)
+	case Value:
+		data, err := decode_json(raw)
+		if err != nil {
+			return err
+		}
+		return value_set_json(v, data)
+	}
+	if mem, ok := go_memory(arg); ok {
+		var data []byte
+		err := json.Unmarshal(raw, &data)
+		if err != nil {
+			return err
+		}
+		if len(data) != len(mem) {
+			return fmt.Errorf("recorded %d bytes for %T, expected %d", len(data), arg, len(mem))
+		}
+		copy(mem, data)
+		return nil
+	}
+	return fmt.Errorf("can not replay the contents of %T", arg)
+}
+
+// replay_result returns the recorded result raw, of type t, as Cif.Call
+// would.
+func replay_result(t Type, raw json.RawMessage) (reflect.Value, error) {
+	rt := rtype_from_type(t)
+	if rt == g_value_type {
+		v := New(t)
+		data, err := decode_json(raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(v), value_set_json(v, data)
+	}
+	out := reflect.New(rt).Elem()
+	if t.Kind() == Void {
+		return out, nil
+	}
+	data, err := decode_json(raw)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	s, err := json_number(data)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	switch rt.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetFloat(x)
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported result type %s", rt)
+	}
+	return out, nil
+}
+
+var _ backend = (*replay_backend)(nil)
+
+// EOF
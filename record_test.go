@@ -0,0 +1,113 @@
+package ffi_test
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+// record_session performs calls through lib, returning their results.
+func record_session(t *testing.T, lib *ffi.Library) []interface{} {
+	//double log(double x);
+	log, err := lib.Fct("log", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("could not locate function [log]: %v", err)
+	}
+	//double frexp(double x, int *exp);
+	frexp, err := lib.Fct("frexp", ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [frexp]: %v", err)
+	}
+	//double modf(double x, double *iptr);
+	modf, err := lib.Fct("modf", ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [modf]: %v", err)
+	}
+	//div_t div(int numerator, int denominator);
+	div_t, err := ffi.NewStructType("div_t", []ffi.Field{
+		{"quot", ffi.C_int32},
+		{"rem", ffi.C_int32},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	div, err := lib.Fct("div", div_t, []ffi.Type{ffi.C_int32, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [div]: %v", err)
+	}
+	//int snprintf(char *str, size_t size, const char *format, ...);
+	snprintf, err := lib.FctVariadic("snprintf", ffi.C_int32, []ffi.Type{ffi.C_pointer, ffi.C_uint64, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [snprintf]: %v", err)
+	}
+
+	var exp int32
+	mant := frexp(8.0, ffi.Out(&exp)).Float()
+	// go pointers and slices are written through as well
+	var exp2 int32
+	mant2 := frexp(48.0, &exp2).Float()
+	ipart := []float64{0}
+	frac := modf(2.5, ipart).Float()
+	qr := div(17, 5).Interface().(ffi.Value)
+	buf := make([]byte, 16)
+	n := snprintf(buf, uint64(len(buf)), "%d-%s", int32(42), "abc").Int()
+	return []interface{}{
+		log(1.0).Float(),
+		log(0.0).Float(),
+		mant, exp,
+		mant2, exp2,
+		frac, ipart[0],
+		qr.Field(0).Int(), qr.Field(1).Int(),
+		n, string(buf[:n]),
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	lib, err := ffi.NewLibrary(libm_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	rec := new(bytes.Buffer)
+	recorder := ffi.NewRecorder(rec)
+	lib = lib.Use(recorder.Interceptor())
+	ref := record_session(t, lib)
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("recording failed: %v", err)
+	}
+	eq(t, []interface{}{0.0, math.Inf(-1), 0.5, int32(4), 0.75, int32(6), 0.5, 2.0, int64(3), int64(2), int64(6), "42-abc"}, ref)
+	eq(t, 7, strings.Count(rec.String(), "\n"))
+
+	replay, err := ffi.NewReplayLibrary(bytes.NewReader(rec.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer replay.Close()
+	eq(t, ref, record_session(t, replay))
+	// recorded calls can be answered again
+	eq(t, ref, record_session(t, replay))
+
+	log, err := replay.Fct("log", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, err = log.Call(2.0)
+	if _, ok := err.(*ffi.CallError); !ok {
+		t.Errorf("expected a *ffi.CallError for a call which was not recorded, got %v", err)
+	}
+
+	_, err = replay.Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err == nil {
+		t.Errorf("expected an error for a function which was not recorded")
+	}
+	_, err = replay.FctPtr("log")
+	if err == nil {
+		t.Errorf("expected an error for the function pointer of a replayed function")
+	}
+}
+
+// EOF
@@ -0,0 +1,190 @@
+package ffi
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PkgConfig is the pkg-config command NewLibrary consults for libraries it
+// could not find otherwise. Set it to "" to disable pkg-config.
+var PkgConfig = "pkg-config"
+
+// LibraryError reports a library NewLibrary could not load.
+type LibraryError struct {
+	Name  string   // name of the library, as given to NewLibrary
+	Tried []string // candidates tried, in order
+	Errs  []error  // why each candidate could not be loaded
+}
+
+func (e *LibraryError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ffi: could not load library [%s]", e.Name)
+	for i, c := range e.Tried {
+		fmt.Fprintf(&b, "\n\t%s: %v", c, e.Errs[i])
+	}
+	return b.String()
+}
+
+func (e *LibraryError) Unwrap() []error {
+	return e.Errs
+}
+
+// resolve_library returns the candidate files for the library libname, in
+// the order they should be tried.
+//
+// A libname holding a '/' is a path, used as is. Otherwise short names
+// ("m") are expanded to library file names ("libm.so"), looked up in the
+// directories of $FFI_LIBRARY_PATH, then of the dynamic linker's search path
+// variable ($LD_LIBRARY_PATH), then in the dynamic linker's cache, the
+// highest versions first. pkg-config is consulted if none of them has the
+// library. The file name itself comes last, for the dynamic linker to find.
+func resolve_library(libname string) []string {
+	if strings.ContainsRune(libname, '/') {
+		return []string{libname}
+	}
+
+	var cands []string
+	seen := make(map[string]bool)
+	add := func(names ...string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				cands = append(cands, name)
+			}
+		}
+	}
+
+	fname := libname
+	if !is_lib_file_name(libname) {
+		fname = get_lib_arch_name(libname)
+	}
+
+	for _, dir := range lib_search_path() {
+		add(find_in_dir(dir, fname)...)
+	}
+	add(ld_cache_lookup(fname)...)
+	if len(cands) == 0 {
+		add(pkg_config_libs(libname)...)
+	}
+	add(fname, libname)
+	return cands
+}
+
+// lib_search_path returns the directories of $FFI_LIBRARY_PATH, then of the
+// search path variable of the dynamic linker.
+func lib_search_path() []string {
+	var dirs []string
+	for _, env := range []string{"FFI_LIBRARY_PATH", g_lib_path_env} {
+		for _, dir := range filepath.SplitList(os.Getenv(env)) {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// find_in_dir returns the paths of the files of dir which are versions of
+// the library file fname, highest version first.
+func find_in_dir(dir, fname string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var libs []versioned_lib
+	for _, e := range entries {
+		if v, ok := lib_version(fname, e.Name()); ok {
+			libs = append(libs, versioned_lib{filepath.Join(dir, e.Name()), v})
+		}
+	}
+	return sort_versions(libs)
+}
+
+// versioned_lib is a library file, with the version numbers of its name.
+type versioned_lib struct {
+	path    string
+	version []int
+}
+
+// sort_versions returns the paths of libs, highest version first.
+// Unversioned files come last: they are usually development symlinks (or
+// linker scripts).
+func sort_versions(libs []versioned_lib) []string {
+	sort.SliceStable(libs, func(i, j int) bool {
+		return compare_versions(libs[i].version, libs[j].version) > 0
+	})
+	paths := make([]string, len(libs))
+	for i, lib := range libs {
+		paths[i] = lib.path
+	}
+	return paths
+}
+
+// compare_versions compares the version numbers a and b, returning -1, 0
+// or +1.
+func compare_versions(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return +1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return +1
+	}
+	return 0
+}
+
+// parse_version parses dot-separated version numbers ("6.0.1").
+func parse_version(s string) ([]int, bool) {
+	var v []int
+	for _, f := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		v = append(v, n)
+	}
+	return v, true
+}
+
+// pkg_config_libs returns the candidate files of the libraries pkg-config
+// lists for the package pkg.
+func pkg_config_libs(pkg string) []string {
+	if PkgConfig == "" {
+		return nil
+	}
+	out, err := exec.Command(PkgConfig, "--libs", pkg).Output()
+	if err != nil {
+		return nil
+	}
+	var dirs, libs []string
+	for _, f := range strings.Fields(string(out)) {
+		switch {
+		case strings.HasPrefix(f, "-L"):
+			dirs = append(dirs, f[2:])
+		case strings.HasPrefix(f, "-l"):
+			libs = append(libs, get_lib_arch_name(f[2:]))
+		}
+	}
+	var cands []string
+	for _, lib := range libs {
+		for _, dir := range dirs {
+			cands = append(cands, find_in_dir(dir, lib)...)
+		}
+		cands = append(cands, ld_cache_lookup(lib)...)
+	}
+	return cands
+}
+
+// EOF
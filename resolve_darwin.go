@@ -0,0 +1,36 @@
+package ffi
+
+import (
+	"strings"
+)
+
+// the search path variable of the dynamic linker
+const g_lib_path_env = "DYLD_LIBRARY_PATH"
+
+// is_lib_file_name returns whether name is a library file name
+// ("libz.dylib", "libz.1.dylib") rather than a short name ("z").
+func is_lib_file_name(name string) bool {
+	return strings.HasPrefix(name, g_lib_prefix) && strings.HasSuffix(name, g_lib_suffix)
+}
+
+// lib_version returns the version numbers of name, if it is a version of the
+// library file fname ("libz.1.dylib" is version 1 of "libz.dylib").
+func lib_version(fname, name string) ([]int, bool) {
+	if name == fname {
+		return nil, true
+	}
+	base := strings.TrimSuffix(fname, g_lib_suffix)
+	if !strings.HasPrefix(name, base+".") || !strings.HasSuffix(name, g_lib_suffix) {
+		return nil, false
+	}
+	v := strings.TrimSuffix(name[len(base)+1:], g_lib_suffix)
+	return parse_version(v)
+}
+
+// ld_cache_lookup returns nil: the dyld shared cache holds the system
+// libraries under their install names, which dlopen resolves by itself.
+func ld_cache_lookup(fname string) []string {
+	return nil
+}
+
+// EOF
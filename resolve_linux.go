@@ -0,0 +1,121 @@
+package ffi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// the search path variable of the dynamic linker
+const g_lib_path_env = "LD_LIBRARY_PATH"
+
+// is_lib_file_name returns whether name is a library file name ("libm.so",
+// "libm.so.6") rather than a short name ("m").
+func is_lib_file_name(name string) bool {
+	return strings.HasPrefix(name, g_lib_prefix) &&
+		(strings.HasSuffix(name, g_lib_suffix) || strings.Contains(name, g_lib_suffix+"."))
+}
+
+// lib_version returns the version numbers of name, if it is a version of the
+// library file fname ("libm.so.6" is version 6 of "libm.so").
+func lib_version(fname, name string) ([]int, bool) {
+	if name == fname {
+		return nil, true
+	}
+	if !strings.HasPrefix(name, fname+".") {
+		return nil, false
+	}
+	return parse_version(name[len(fname)+1:])
+}
+
+const g_ld_cache = "/etc/ld.so.cache"
+
+// the layout of the (new format) ld.so cache, see glibc's dl-cache.h
+var g_ld_cache_magic = []byte("glibc-ld.so.cache1.1")
+
+const (
+	g_ld_cache_header_size = 48
+	g_ld_cache_entry_size  = 24
+	g_ld_cache_flags_mask  = 0xffff // FLAG_TYPE_MASK | FLAG_REQUIRED_MASK
+)
+
+// the flags of the cache entries for the libraries of each arch
+var g_ld_cache_flags = map[string]uint32{
+	"386":     0x0003, // FLAG_ELF_LIBC6
+	"amd64":   0x0303, // FLAG_ELF_LIBC6 | FLAG_X8664_LIB64
+	"arm64":   0x0a03, // FLAG_ELF_LIBC6 | FLAG_AARCH64_LIB64
+	"riscv64": 0x1003, // FLAG_ELF_LIBC6 | FLAG_RISCV_FLOAT_ABI_DOUBLE
+}
+
+// ld_cache_entry is a library listed in the ld.so cache.
+type ld_cache_entry struct {
+	flags uint32
+	name  string // SONAME of the library
+	path  string
+}
+
+// ld_cache_lookup returns the paths of the versions of the library file
+// fname listed in the ld.so cache for the running arch, highest version
+// first.
+func ld_cache_lookup(fname string) []string {
+	data, err := os.ReadFile(g_ld_cache)
+	if err != nil {
+		return nil
+	}
+	want, check := g_ld_cache_flags[runtime.GOARCH]
+	var libs []versioned_lib
+	for _, e := range parse_ld_cache(data) {
+		if check && e.flags&g_ld_cache_flags_mask != want {
+			continue
+		}
+		if v, ok := lib_version(fname, e.name); ok {
+			libs = append(libs, versioned_lib{e.path, v})
+		}
+	}
+	return sort_versions(libs)
+}
+
+// parse_ld_cache returns the entries of the ld.so cache data.
+// Only the new format is understood, on its own or following the old one.
+func parse_ld_cache(data []byte) []ld_cache_entry {
+	start := bytes.Index(data, g_ld_cache_magic)
+	if start < 0 {
+		return nil
+	}
+	// string offsets are relative to the new format header
+	hdr := data[start:]
+	if len(hdr) < g_ld_cache_header_size {
+		return nil
+	}
+	bo := binary.NativeEndian
+	n := int(bo.Uint32(hdr[len(g_ld_cache_magic):]))
+	if n < 0 || (len(hdr)-g_ld_cache_header_size)/g_ld_cache_entry_size < n {
+		return nil
+	}
+	entries := make([]ld_cache_entry, 0, n)
+	for i := 0; i < n; i++ {
+		e := hdr[g_ld_cache_header_size+i*g_ld_cache_entry_size:]
+		entries = append(entries, ld_cache_entry{
+			flags: bo.Uint32(e[0:]),
+			name:  c_string_at(hdr, bo.Uint32(e[4:])),
+			path:  c_string_at(hdr, bo.Uint32(e[8:])),
+		})
+	}
+	return entries
+}
+
+// c_string_at returns the NUL-terminated string at offset off of buf.
+func c_string_at(buf []byte, off uint32) string {
+	if uint64(off) >= uint64(len(buf)) {
+		return ""
+	}
+	s := buf[off:]
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return string(s)
+}
+
+// EOF
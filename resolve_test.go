@@ -0,0 +1,71 @@
+package ffi_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestNewLibraryShortName(t *testing.T) {
+	lib, err := ffi.NewLibrary("m")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	cos, err := lib.Fct("cos", ffi.C_double, []ffi.Type{ffi.C_double})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, 1.0, cos(0.0).Float())
+}
+
+func TestNewLibraryNotFound(t *testing.T) {
+	_, err := ffi.NewLibrary("no-such-ffi-library")
+	if err == nil {
+		t.Fatalf("expected an error loading a missing library")
+	}
+	var lerr *ffi.LibraryError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("expected a *LibraryError (got %T)", err)
+	}
+	if len(lerr.Tried) == 0 || len(lerr.Tried) != len(lerr.Errs) {
+		t.Errorf("expected the candidates tried (got %v, %v)", lerr.Tried, lerr.Errs)
+	}
+}
+
+func TestNewLibrarySearchPath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("versioned library names of linux")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"libffitest.so", "libffitest.so.1", "libffitest.so.10", "libffitest.so.2.1", "libffitest.so.x"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("not an ELF file"), 0644)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	t.Setenv("FFI_LIBRARY_PATH", dir)
+
+	_, err := ffi.NewLibrary("ffitest")
+	var lerr *ffi.LibraryError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("expected a *LibraryError (got %v)", err)
+	}
+	ref := []string{
+		filepath.Join(dir, "libffitest.so.10"),
+		filepath.Join(dir, "libffitest.so.2.1"),
+		filepath.Join(dir, "libffitest.so.1"),
+		filepath.Join(dir, "libffitest.so"),
+	}
+	if len(lerr.Tried) < len(ref) {
+		t.Fatalf("expected at least %d candidates (got %v)", len(ref), lerr.Tried)
+	}
+	eq(t, ref, lerr.Tried[:len(ref)])
+}
+
+// EOF
@@ -0,0 +1,807 @@
+package ffi
+
+// #include <signal.h>
+// #include <stdlib.h>
+// #include "ffi.h"
+// static void _go_ffi_sandbox_default_signals(void)
+// {
+//   int sigs[] = {SIGSEGV, SIGBUS, SIGFPE, SIGILL, SIGABRT, SIGXCPU};
+//   size_t i;
+//   for (i = 0; i < sizeof(sigs)/sizeof(sigs[0]); i++) {
+//     signal(sigs[i], SIG_DFL);
+//   }
+// }
+import "C"
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// environment variables describing the helper process of a SandboxLibrary
+const (
+	g_sandbox_lib_env = "GO_FFI_SANDBOX_LIB"
+	g_sandbox_mem_env = "GO_FFI_SANDBOX_RLIMIT_AS"
+	g_sandbox_cpu_env = "GO_FFI_SANDBOX_RLIMIT_CPU"
+)
+
+// file descriptors of the request and response pipes in the helper process
+const (
+	g_sandbox_req_fd  = 3
+	g_sandbox_resp_fd = 4
+)
+
+// SandboxLimits are the resource limits of the helper process of a
+// SandboxLibrary. Zero values mean no limit.
+type SandboxLimits struct {
+	Memory  uint64        // maximum size of the address space, in bytes (RLIMIT_AS)
+	CPU     time.Duration // maximum CPU time, rounded up to the second (RLIMIT_CPU)
+	Timeout time.Duration // maximum duration of a call, the helper is killed past it
+}
+
+// SandboxLibrary is a library dl-opened in a helper process.
+// Calls are forwarded to the helper, so that crashes of the C code do not
+// take the go process down: they are reported as SandboxError and the
+// helper is respawned at the next call.
+//
+// The helper process is a re-execution of the current binary, which has to
+// call RunSandboxHelper at the start of its main function (or TestMain).
+//
+// Arguments are marshalled by value: scalars and structs are converted as by
+// Cif.Call (go structs with the Encoder), strings are passed as C strings
+// and []byte slices, ffi.Values and OutParams as buffers whose contents are
+// copied back after the call (OutParams with the Decoder). The resulting C
+// bytes are sent to the helper with encoding/gob.
+// Other pointers can not be passed, and pointer results are addresses in
+// the helper process.
+//
+// The helper runs one call at a time: concurrent calls are serialized, each
+// waiting for the previous ones until its context is done. A hung call
+// holds the others back until its context is done or SandboxLimits.Timeout
+// elapses, which kills the helper.
+type SandboxLibrary struct {
+	name   string
+	limits SandboxLimits
+
+	busy chan struct{} // held during the exchanges with the helper
+
+	mu     sync.Mutex
+	h      *sandbox_helper // the running helper, nil if none
+	fcts   []sandbox_fct   // the functions declared so far
+	closed bool
+}
+
+// SandboxFunction is a function of a SandboxLibrary.
+// The call is aborted, and the helper process killed, when ctx is done.
+type SandboxFunction func(ctx context.Context, args ...interface{}) (reflect.Value, error)
+
+// SandboxError reports a call through a SandboxLibrary which failed because
+// the helper process crashed, was killed or could not be started.
+type SandboxError struct {
+	Fct    string         // name of the called function
+	Signal syscall.Signal // signal which terminated the helper, if any
+	Err    error          // underlying error
+}
+
+func (e *SandboxError) Error() string {
+	return fmt.Sprintf("ffi: sandboxed call to [%s] failed: %v", e.Fct, e.Err)
+}
+
+func (e *SandboxError) Unwrap() error {
+	return e.Err
+}
+
+// sandbox_fct is a function declared to the helper processes
+type sandbox_fct struct {
+	name  string
+	rtype Type
+	args  []Type
+}
+
+// sandbox_helper is a running helper process
+type sandbox_helper struct {
+	cmd *exec.Cmd
+	w   *os.File
+	enc *gob.Encoder
+	dec *gob.Decoder
+
+	done chan struct{} // closed once the process exited
+	werr error         // result of cmd.Wait
+}
+
+// sandbox operations
+const (
+	sandbox_op_fct  = iota // declare a function
+	sandbox_op_call        // call a declared function
+)
+
+// sandbox argument kinds
+const (
+	sandbox_arg_value  = iota // C value of the declared type
+	sandbox_arg_buffer        // pointer to a copy of the data
+	sandbox_arg_nil           // NULL pointer
+)
+
+type sandbox_request struct {
+	Op    int
+	Fct   int // index of the function
+	Name  string
+	RType *sandbox_type
+	Args  []*sandbox_type
+	Vals  []sandbox_arg
+}
+
+type sandbox_arg struct {
+	Kind int
+	Data []byte
+}
+
+type sandbox_response struct {
+	Err  string
+	Ret  []byte   // C result, widened to a ffi_arg
+	Bufs [][]byte // contents of the buffer arguments after the call
+}
+
+// sandbox_type describes a Type to the helper process
+type sandbox_type struct {
+	Name   string
+	Kind   Kind
+	Len    int
+	Elem   *sandbox_type
+	Fields []sandbox_field
+}
+
+type sandbox_field struct {
+	Name string
+	Type *sandbox_type
+}
+
+// NewSandboxLibrary starts a helper process dl-opening libname, within the
+// resource limits.
+func NewSandboxLibrary(libname string, limits SandboxLimits) (*SandboxLibrary, error) {
+	lib := &SandboxLibrary{name: libname, limits: limits, busy: make(chan struct{}, 1)}
+	h, err := lib.spawn()
+	if err != nil {
+		return nil, err
+	}
+	lib.h = h
+	return lib, nil
+}
+
+// Close stops the helper process, aborting the call in flight if any.
+func (lib *SandboxLibrary) Close() error {
+	lib.mu.Lock()
+	if lib.closed {
+		lib.mu.Unlock()
+		return fmt.Errorf("ffi.SandboxLibrary.Close: library already closed")
+	}
+	lib.closed = true
+	h := lib.h
+	lib.h = nil
+	lib.mu.Unlock()
+	if h != nil {
+		h.stop()
+	}
+	return nil
+}
+
+// acquire waits for the exchanges with the helper in flight to complete, or
+// for ctx to be done.
+func (lib *SandboxLibrary) acquire(ctx context.Context) error {
+	select {
+	case lib.busy <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (lib *SandboxLibrary) release() {
+	<-lib.busy
+}
+
+// with_timeout returns ctx, bounded by the call timeout of lib if any.
+func (lib *SandboxLibrary) with_timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if lib.limits.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, lib.limits.Timeout)
+}
+
+// Fct returns a SandboxFunction calling fctname in the helper process.
+func (lib *SandboxLibrary) Fct(fctname string, rtype Type, argtypes []Type) (SandboxFunction, error) {
+	for _, t := range argtypes {
+		switch t.Kind() {
+		case Void, LongDouble:
+			return nil, fmt.Errorf("ffi.SandboxLibrary.Fct: unsupported argument type [%s]", t.Name())
+		}
+	}
+
+	ctx, cancel := lib.with_timeout(context.Background())
+	defer cancel()
+	err := lib.acquire(ctx)
+	if err != nil {
+		return nil, &SandboxError{Fct: fctname, Err: err}
+	}
+	defer lib.release()
+
+	fct := sandbox_fct{fctname, rtype, argtypes}
+	h, err := lib.helper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resp sandbox_response
+	err = h.roundtrip(ctx, fct.request(len(lib.fcts)), &resp)
+	if err != nil {
+		lib.kill(h)
+		return nil, &SandboxError{Fct: fctname, Signal: h.signal(), Err: err}
+	}
+	if resp.Err != "" {
+		return nil, errors.New(resp.Err)
+	}
+	lib.mu.Lock()
+	id := len(lib.fcts)
+	lib.fcts = append(lib.fcts, fct)
+	lib.mu.Unlock()
+
+	fn := func(ctx context.Context, args ...interface{}) (reflect.Value, error) {
+		return lib.call(ctx, id, fct, args)
+	}
+	return SandboxFunction(fn), nil
+}
+
+func (fct sandbox_fct) request(id int) sandbox_request {
+	req := sandbox_request{
+		Op:    sandbox_op_fct,
+		Fct:   id,
+		Name:  fct.name,
+		RType: sandbox_type_from(fct.rtype),
+		Args:  make([]*sandbox_type, len(fct.args)),
+	}
+	for i, t := range fct.args {
+		req.Args[i] = sandbox_type_from(t)
+	}
+	return req
+}
+
+// call performs the call of fct, the id-th function, in the helper process.
+func (lib *SandboxLibrary) call(ctx context.Context, id int, fct sandbox_fct, args []interface{}) (reflect.Value, error) {
+	if len(args) != len(fct.args) {
+		return reflect.Value{}, &CallError{-1, fmt.Sprintf(
+			"invalid number of arguments. expected '%d', got '%d'.",
+			len(fct.args), len(args))}
+	}
+
+	req := sandbox_request{Op: sandbox_op_call, Fct: id, Vals: make([]sandbox_arg, len(args))}
+	var bufs []func([]byte) error // write-backs of the buffer arguments
+	for i, arg := range args {
+		v, wb, err := sandbox_arg_from(i, fct.args[i], arg)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		req.Vals[i] = v
+		if v.Kind == sandbox_arg_buffer {
+			bufs = append(bufs, wb)
+		}
+	}
+
+	ctx, cancel := lib.with_timeout(ctx)
+	defer cancel()
+	err := lib.acquire(ctx)
+	if err != nil {
+		return reflect.Value{}, &SandboxError{Fct: fct.name, Err: err}
+	}
+	defer lib.release()
+
+	h, err := lib.helper(ctx)
+	if err != nil {
+		return reflect.Value{}, &SandboxError{Fct: fct.name, Err: err}
+	}
+
+	var resp sandbox_response
+	err = h.roundtrip(ctx, req, &resp)
+	if err != nil {
+		lib.kill(h)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return reflect.Value{}, &SandboxError{Fct: fct.name, Signal: h.signal(), Err: err}
+	}
+	if resp.Err != "" {
+		return reflect.Value{}, errors.New(resp.Err)
+	}
+
+	buf := result_buffer(fct.rtype)
+	copy(unsafe.Slice((*byte)(buf), len(resp.Ret)), resp.Ret)
+	out := goresult(fct.rtype, buf)
+
+	if len(resp.Bufs) != len(bufs) {
+		return out, fmt.Errorf("ffi: sandboxed call to [%s]: invalid response", fct.name)
+	}
+	for i, wb := range bufs {
+		if wb == nil {
+			continue
+		}
+		err = wb(resp.Bufs[i])
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// helper returns the running helper process, respawning it if needed.
+// It is called with the exchanges with the helper held, see acquire.
+func (lib *SandboxLibrary) helper(ctx context.Context) (*sandbox_helper, error) {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	if lib.closed {
+		return nil, fmt.Errorf("ffi.SandboxLibrary: library closed")
+	}
+	if lib.h != nil {
+		return lib.h, nil
+	}
+	h, err := lib.spawn()
+	if err != nil {
+		return nil, err
+	}
+	// declare the functions again
+	for i, fct := range lib.fcts {
+		var resp sandbox_response
+		err = h.roundtrip(ctx, fct.request(i), &resp)
+		if err == nil && resp.Err != "" {
+			err = errors.New(resp.Err)
+		}
+		if err != nil {
+			h.stop()
+			return nil, err
+		}
+	}
+	lib.h = h
+	return h, nil
+}
+
+// kill terminates the helper process h, after a failed call.
+func (lib *SandboxLibrary) kill(h *sandbox_helper) {
+	h.cmd.Process.Kill()
+	<-h.done
+	h.w.Close()
+	lib.mu.Lock()
+	if lib.h == h {
+		lib.h = nil
+	}
+	lib.mu.Unlock()
+}
+
+// spawn starts a new helper process.
+func (lib *SandboxLibrary) spawn() (*sandbox_helper, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("ffi.SandboxLibrary: %v", err)
+	}
+	req_r, req_w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("ffi.SandboxLibrary: %v", err)
+	}
+	resp_r, resp_w, err := os.Pipe()
+	if err != nil {
+		req_r.Close()
+		req_w.Close()
+		return nil, fmt.Errorf("ffi.SandboxLibrary: %v", err)
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), g_sandbox_lib_env+"="+lib.name)
+	if lib.limits.Memory > 0 {
+		cmd.Env = append(cmd.Env, g_sandbox_mem_env+"="+strconv.FormatUint(lib.limits.Memory, 10))
+	}
+	if lib.limits.CPU > 0 {
+		secs := uint64((lib.limits.CPU + time.Second - 1) / time.Second)
+		cmd.Env = append(cmd.Env, g_sandbox_cpu_env+"="+strconv.FormatUint(secs, 10))
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{req_r, resp_w}
+	err = cmd.Start()
+	req_r.Close()
+	resp_w.Close()
+	if err != nil {
+		req_w.Close()
+		resp_r.Close()
+		return nil, fmt.Errorf("ffi.SandboxLibrary: could not start helper: %v", err)
+	}
+
+	h := &sandbox_helper{
+		cmd:  cmd,
+		w:    req_w,
+		enc:  gob.NewEncoder(req_w),
+		dec:  gob.NewDecoder(resp_r),
+		done: make(chan struct{}),
+	}
+	go func() {
+		h.werr = cmd.Wait()
+		resp_r.Close()
+		close(h.done)
+	}()
+
+	// the helper greets us once the library is opened
+	var hello sandbox_response
+	err = h.dec.Decode(&hello)
+	if err != nil {
+		h.stop()
+		return nil, fmt.Errorf("ffi.SandboxLibrary: helper did not start (%v)", h.werr)
+	}
+	if hello.Err != "" {
+		h.stop()
+		return nil, errors.New(hello.Err)
+	}
+	return h, nil
+}
+
+// roundtrip sends req to the helper and waits for its response, or for ctx
+// to be done.
+func (h *sandbox_helper) roundtrip(ctx context.Context, req sandbox_request, resp *sandbox_response) error {
+	errc := make(chan error, 1)
+	go func() {
+		err := h.enc.Encode(req)
+		if err == nil {
+			err = h.dec.Decode(resp)
+		}
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			// the helper died: report how.
+			h.cmd.Process.Kill()
+			<-h.done
+			if h.werr != nil {
+				return h.werr
+			}
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// signal returns the signal which terminated the helper process, if any.
+func (h *sandbox_helper) signal() syscall.Signal {
+	select {
+	case <-h.done:
+	default:
+		return 0
+	}
+	if h.cmd.ProcessState == nil {
+		return 0
+	}
+	ws, ok := h.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return 0
+	}
+	return ws.Signal()
+}
+
+// stop asks the helper process to exit, killing it if it does not.
+func (h *sandbox_helper) stop() {
+	h.w.Close()
+	select {
+	case <-h.done:
+	case <-time.After(time.Second):
+		h.cmd.Process.Kill()
+		<-h.done
+	}
+}
+
+// sandbox_arg_from marshals arg, the i-th argument of the call, of the
+// declared type t.
+// The returned function writes back the contents of buffer arguments.
+func sandbox_arg_from(i int, t Type, arg interface{}) (sandbox_arg, func([]byte) error, error) {
+	switch t.Kind() {
+	case Ptr, Array:
+		switch v := arg.(type) {
+		case nil:
+			return sandbox_arg{Kind: sandbox_arg_nil}, nil, nil
+		case string:
+			data := append([]byte(v), 0)
+			return sandbox_arg{sandbox_arg_buffer, data}, nil, nil
+		case []byte:
+			wb := func(data []byte) error {
+				copy(v, data)
+				return nil
+			}
+			return sandbox_arg{sandbox_arg_buffer, v}, wb, nil
+		case OutParam:
+			cval, err := v.encode()
+			if err != nil {
+				return sandbox_arg{}, nil, &CallError{i, err.Error()}
+			}
+			wb := func(data []byte) error {
+				copy(value_bytes(cval), data)
+				err := v.decode(cval)
+				if err != nil {
+					return &CallError{i, err.Error()}
+				}
+				return nil
+			}
+			return sandbox_arg{sandbox_arg_buffer, value_bytes(cval)}, wb, nil
+		case Value:
+			if v.IsValid() && v.Kind() != Ptr {
+				wb := func(data []byte) error {
+					copy(value_bytes(v), data)
+					return nil
+				}
+				return sandbox_arg{sandbox_arg_buffer, value_bytes(v)}, wb, nil
+			}
+		}
+		return sandbox_arg{}, nil, &CallError{i, fmt.Sprintf("can not pass %T across the sandbox", arg)}
+	}
+
+	frame := new_call_frame(i + 1)
+	defer frame.free()
+	err := frame.set(i, t, arg)
+	if err != nil {
+		return sandbox_arg{}, nil, err
+	}
+	data := make([]byte, t.Size())
+	copy(data, unsafe.Slice((*byte)(frame.cargs[i]), t.Size()))
+	return sandbox_arg{sandbox_arg_value, data}, nil, nil
+}
+
+// value_bytes returns the memory of the value v.
+func value_bytes(v Value) []byte {
+	sz := v.Type().Size()
+	if sz == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(v.val), sz)
+}
+
+// sandbox_type_from returns the description of the type t.
+func sandbox_type_from(t Type) *sandbox_type {
+	d := &sandbox_type{Name: t.Name(), Kind: t.Kind()}
+	switch t.Kind() {
+	case Struct:
+		d.Fields = make([]sandbox_field, t.NumField())
+		for i := range d.Fields {
+			f := t.Field(i)
+			d.Fields[i] = sandbox_field{f.Name, sandbox_type_from(f.Type)}
+		}
+	case Array:
+		d.Len = t.Len()
+		d.Elem = sandbox_type_from(t.Elem())
+	case Slice:
+		d.Elem = sandbox_type_from(t.Elem())
+	}
+	return d
+}
+
+// type_from_sandbox returns the type described by d.
+func type_from_sandbox(d *sandbox_type) (Type, error) {
+	switch d.Kind {
+	case Struct:
+		fields := make([]Field, len(d.Fields))
+		for i, f := range d.Fields {
+			ft, err := type_from_sandbox(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = Field{f.Name, ft}
+		}
+		return NewStructType(d.Name, fields)
+	case Array:
+		elem, err := type_from_sandbox(d.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return NewArrayType(d.Len, elem)
+	case Slice:
+		elem, err := type_from_sandbox(d.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return NewSliceType(elem)
+	case Ptr:
+		// only the pointer-ness matters to libffi
+		return C_pointer, nil
+	}
+	t := TypeByName(d.Name)
+	if t == nil {
+		return nil, fmt.Errorf("ffi: unknown type [%s]", d.Name)
+	}
+	return t, nil
+}
+
+// RunSandboxHelper runs the helper process of a SandboxLibrary, and exits,
+// if the current process was started as such a helper.
+// It returns immediately otherwise.
+func RunSandboxHelper() {
+	libname, ok := os.LookupEnv(g_sandbox_lib_env)
+	if !ok {
+		return
+	}
+	r := os.NewFile(g_sandbox_req_fd, "ffi-sandbox-req")
+	w := os.NewFile(g_sandbox_resp_fd, "ffi-sandbox-resp")
+	enc := gob.NewEncoder(w)
+	dec := gob.NewDecoder(r)
+
+	var hello sandbox_response
+	lib, err := sandbox_helper_open(libname)
+	if err != nil {
+		hello.Err = err.Error()
+	}
+	if enc.Encode(hello) != nil || err != nil {
+		os.Exit(1)
+	}
+
+	// let the fatal signals terminate the helper, so that the parent sees
+	// them, instead of the go runtime reporting them.
+	C._go_ffi_sandbox_default_signals()
+
+	srv := sandbox_server{lib: lib}
+	for {
+		var req sandbox_request
+		if dec.Decode(&req) != nil {
+			// the parent went away
+			os.Exit(0)
+		}
+		resp := srv.serve(&req)
+		if enc.Encode(resp) != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+// sandbox_helper_open applies the resource limits of the helper process and
+// dl-opens the library.
+func sandbox_helper_open(libname string) (*Library, error) {
+	for _, rl := range []struct {
+		env string
+		res int
+	}{
+		{g_sandbox_mem_env, syscall.RLIMIT_AS},
+		{g_sandbox_cpu_env, syscall.RLIMIT_CPU},
+	} {
+		s := os.Getenv(rl.env)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ffi.SandboxLibrary: invalid limit %s=%q", rl.env, s)
+		}
+		err = syscall.Setrlimit(rl.res, &syscall.Rlimit{Cur: n, Max: n})
+		if err != nil {
+			return nil, fmt.Errorf("ffi.SandboxLibrary: could not set %s: %v", rl.env, err)
+		}
+	}
+	return NewLibrary(libname)
+}
+
+// sandbox_server serves the requests in the helper process
+type sandbox_server struct {
+	lib  *Library
+	fcts []sandbox_server_fct
+}
+
+type sandbox_server_fct struct {
+	fct FctPtr
+	cif *Cif
+}
+
+func (srv *sandbox_server) serve(req *sandbox_request) (resp sandbox_response) {
+	var err error
+	switch req.Op {
+	case sandbox_op_fct:
+		err = srv.declare(req)
+	case sandbox_op_call:
+		resp, err = srv.call(req)
+	default:
+		err = fmt.Errorf("ffi: invalid sandbox request (op=%d)", req.Op)
+	}
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	return resp
+}
+
+func (srv *sandbox_server) declare(req *sandbox_request) error {
+	if req.Fct != len(srv.fcts) {
+		return fmt.Errorf("ffi: invalid sandbox function id (%d)", req.Fct)
+	}
+	rtype, err := type_from_sandbox(req.RType)
+	if err != nil {
+		return err
+	}
+	args := make([]Type, len(req.Args))
+	for i, d := range req.Args {
+		args[i], err = type_from_sandbox(d)
+		if err != nil {
+			return err
+		}
+	}
+	fct, err := srv.lib.FctPtr(req.Name)
+	if err != nil {
+		return err
+	}
+	cif, err := NewCif(DefaultAbi, rtype, args)
+	if err != nil {
+		return err
+	}
+	srv.fcts = append(srv.fcts, sandbox_server_fct{fct, cif})
+	return nil
+}
+
+// call performs the call, with the arguments copied into C memory.
+func (srv *sandbox_server) call(req *sandbox_request) (resp sandbox_response, err error) {
+	if req.Fct < 0 || req.Fct >= len(srv.fcts) {
+		return resp, fmt.Errorf("ffi: invalid sandbox function id (%d)", req.Fct)
+	}
+	fct := srv.fcts[req.Fct]
+	nargs := len(req.Vals)
+	if nargs != len(fct.cif.args) {
+		return resp, fmt.Errorf("ffi: invalid number of arguments (%d)", nargs)
+	}
+
+	var cmem []unsafe.Pointer
+	defer func() {
+		for _, p := range cmem {
+			C.free(p)
+		}
+	}()
+	cbytes := func(data []byte, sz uintptr) unsafe.Pointer {
+		p := C.calloc(1, C.size_t(max_uintptr(sz, 1)))
+		cmem = append(cmem, p)
+		copy(unsafe.Slice((*byte)(p), sz), data)
+		return p
+	}
+
+	var c_args *unsafe.Pointer
+	var bufs []unsafe.Pointer
+	var sizes []int
+	if nargs > 0 {
+		p := cbytes(nil, ptrSize*uintptr(nargs))
+		c_args = (*unsafe.Pointer)(p)
+		cargs := unsafe.Slice(c_args, nargs)
+		for i, v := range req.Vals {
+			switch v.Kind {
+			case sandbox_arg_value:
+				cargs[i] = cbytes(v.Data, fct.cif.args[i].Size())
+			case sandbox_arg_buffer:
+				buf := cbytes(v.Data, uintptr(len(v.Data)))
+				bufs = append(bufs, buf)
+				sizes = append(sizes, len(v.Data))
+				slot := cbytes(nil, ptrSize)
+				*(*unsafe.Pointer)(slot) = buf
+				cargs[i] = slot
+			case sandbox_arg_nil:
+				cargs[i] = cbytes(nil, ptrSize)
+			default:
+				return resp, fmt.Errorf("ffi: invalid sandbox argument #%d", i)
+			}
+		}
+	}
+
+	rsize := max_uintptr(fct.cif.rtype.Size(), unsafe.Sizeof(C.ffi_arg(0)))
+	c_out := cbytes(nil, rsize)
+	C.ffi_call(&fct.cif.c, fct.fct.c, c_out, c_args)
+
+	resp.Ret = C.GoBytes(c_out, C.int(rsize))
+	resp.Bufs = make([][]byte, len(bufs))
+	for i, buf := range bufs {
+		resp.Bufs[i] = C.GoBytes(buf, C.int(sizes[i]))
+	}
+	return resp, nil
+}
+
+// EOF
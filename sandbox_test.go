@@ -0,0 +1,276 @@
+package ffi_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestMain(m *testing.M) {
+	ffi.RunSandboxHelper()
+	os.Exit(m.Run())
+}
+
+func TestSandboxLibrary(t *testing.T) {
+	lib, err := ffi.NewSandboxLibrary(libc_name, ffi.SandboxLimits{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	ctx := context.Background()
+
+	//size_t strlen(const char *s);
+	strlen, err := lib.Fct("strlen", ffi.C_uint64, []ffi.Type{ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [strlen]: %v", err)
+	}
+	out, err := strlen(ctx, "hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, uint64(5), out.Uint())
+
+	//void *memset(void *s, int c, size_t n);
+	memset, err := lib.Fct("memset", ffi.C_pointer, []ffi.Type{ffi.C_pointer, ffi.C_int32, ffi.C_uint64})
+	if err != nil {
+		t.Fatalf("could not locate function [memset]: %v", err)
+	}
+	buf := []byte("abcdef")
+	_, err = memset(ctx, buf, 'x', 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, "xxxdef", string(buf))
+
+	//div_t div(int numerator, int denominator);
+	div_t, err := ffi.NewStructType("div_t", []ffi.Field{
+		{"quot", ffi.C_int32},
+		{"rem", ffi.C_int32},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	div, err := lib.Fct("div", div_t, []ffi.Type{ffi.C_int32, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [div]: %v", err)
+	}
+	out, err = div(ctx, 17, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v := out.Interface().(ffi.Value)
+	eq(t, int64(3), v.Field(0).Int())
+	eq(t, int64(2), v.Field(1).Int())
+
+	//long strtol(const char *nptr, char **endptr, int base);
+	strtol, err := lib.Fct("strtol", ffi.C_int64, []ffi.Type{ffi.C_pointer, ffi.C_pointer, ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [strtol]: %v", err)
+	}
+	out, err = strtol(ctx, "0x2a", nil, 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, int64(42), out.Int())
+
+	// go pointers can not cross the sandbox
+	_, err = strtol(ctx, "42", new(uintptr), 10)
+	if _, ok := err.(*ffi.CallError); !ok {
+		t.Errorf("expected a *ffi.CallError, got %T (%v)", err, err)
+	}
+
+	_, err = lib.Fct("no_such_function", ffi.C_void, nil)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestSandboxLibraryOutParam(t *testing.T) {
+	lib, err := ffi.NewSandboxLibrary(libm_name, ffi.SandboxLimits{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//double frexp(double x, int *exp);
+	frexp, err := lib.Fct("frexp", ffi.C_double, []ffi.Type{ffi.C_double, ffi.C_pointer})
+	if err != nil {
+		t.Fatalf("could not locate function [frexp]: %v", err)
+	}
+	var exp int32
+	out, err := frexp(context.Background(), 8.0, ffi.Out(&exp))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, 0.5, out.Float())
+	eq(t, int32(4), exp)
+}
+
+func TestSandboxLibraryCrash(t *testing.T) {
+	lib, err := ffi.NewSandboxLibrary(libc_name, ffi.SandboxLimits{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+	ctx := context.Background()
+
+	//int raise(int sig);
+	raise, err := lib.Fct("raise", ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [raise]: %v", err)
+	}
+	//int abs(int j);
+	abs, err := lib.Fct("abs", ffi.C_int32, []ffi.Type{ffi.C_int32})
+	if err != nil {
+		t.Fatalf("could not locate function [abs]: %v", err)
+	}
+
+	_, err = raise(ctx, int32(syscall.SIGSEGV))
+	serr, ok := err.(*ffi.SandboxError)
+	if !ok {
+		t.Fatalf("expected a *ffi.SandboxError, got %T (%v)", err, err)
+	}
+	eq(t, "raise", serr.Fct)
+	eq(t, syscall.SIGSEGV, serr.Signal)
+
+	// the helper is respawned
+	out, err := abs(ctx, -3)
+	if err != nil {
+		t.Fatalf("unexpected error after respawn: %v", err)
+	}
+	eq(t, int64(3), out.Int())
+}
+
+func TestSandboxLibraryTimeout(t *testing.T) {
+	lib, err := ffi.NewSandboxLibrary(libc_name, ffi.SandboxLimits{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//unsigned int sleep(unsigned int seconds);
+	sleep, err := lib.Fct("sleep", ffi.C_uint32, []ffi.Type{ffi.C_uint32})
+	if err != nil {
+		t.Fatalf("could not locate function [sleep]: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = sleep(ctx, 10)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("call was not aborted (%v)", d)
+	}
+
+	out, err := sleep(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error after respawn: %v", err)
+	}
+	eq(t, uint64(0), out.Uint())
+}
+
+func TestSandboxLibraryCallTimeout(t *testing.T) {
+	lib, err := ffi.NewSandboxLibrary(libc_name, ffi.SandboxLimits{Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//unsigned int sleep(unsigned int seconds);
+	sleep, err := lib.Fct("sleep", ffi.C_uint32, []ffi.Type{ffi.C_uint32})
+	if err != nil {
+		t.Fatalf("could not locate function [sleep]: %v", err)
+	}
+
+	// a call waiting behind a hung one gives up with its context
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sleep(context.Background(), 10)
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = sleep(ctx, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error waiting for the hung call, got %v", err)
+	}
+
+	start := time.Now()
+	err = <-errc
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("hung call was not aborted (%v)", d)
+	}
+
+	out, err := sleep(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error after respawn: %v", err)
+	}
+	eq(t, uint64(0), out.Uint())
+}
+
+func TestSandboxLibraryLimits(t *testing.T) {
+	// the helper re-executes the test binary: leave it the address space
+	// used by this process, and some more.
+	used, err := vm_size()
+	if err != nil {
+		t.Skipf("could not measure the address space: %v", err)
+	}
+	limit := used + 512<<20
+	lib, err := ffi.NewSandboxLibrary(libc_name, ffi.SandboxLimits{Memory: limit})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//void *malloc(size_t size);
+	malloc, err := lib.Fct("malloc", ffi.C_pointer, []ffi.Type{ffi.C_uint64})
+	if err != nil {
+		t.Fatalf("could not locate function [malloc]: %v", err)
+	}
+	out, err := malloc(context.Background(), uint64(1<<20))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Uint() == 0 {
+		t.Errorf("expected a small allocation to fit in the limit")
+	}
+	// an allocation larger than the whole address space allowed
+	out, err = malloc(context.Background(), limit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eq(t, uint64(0), out.Uint())
+}
+
+// vm_size returns the size of the address space of the process.
+func vm_size() (uint64, error) {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "VmSize:") {
+			continue
+		}
+		var kb uint64
+		_, err = fmt.Sscanf(strings.TrimPrefix(line, "VmSize:"), "%d kB", &kb)
+		return kb << 10, err
+	}
+	return 0, fmt.Errorf("no VmSize in /proc/self/status")
+}
+
+// EOF
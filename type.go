@@ -3,6 +3,7 @@ package ffi
 import (
 	"fmt"
 	"reflect"
+	"runtime"
 	"unsafe"
 )
 
@@ -321,6 +322,7 @@ func NewStructType(name string, fields []Field) (Type, error) {
 		return t, nil
 	}
 	c := C.ffi_type{}
+	g_types_pinner.Pin(&c)
 	t := &cffi_struct{
 		cffi_type: cffi_type{n: name, c: &c},
 		fields:    make([]StructField, len(fields)),
@@ -337,6 +339,7 @@ func NewStructType(name string, fields []Field) (Type, error) {
 		}
 		cargs[len(fields)] = nil
 		c_fields = &cargs[0]
+		g_types_pinner.Pin(c_fields)
 	}
 	C._go_ffi_type_set_elements(t.cptr(), unsafe.Pointer(c_fields))
 
@@ -386,6 +389,7 @@ func NewArrayType(sz int, elmt Type) (Type, error) {
 		return t, nil
 	}
 	c := C.ffi_type{}
+	g_types_pinner.Pin(&c)
 	t := &cffi_array{
 		cffi_type: cffi_type{n: n, c: &c},
 		len:       sz,
@@ -423,6 +427,7 @@ func NewPointerType(elmt Type) (Type, error) {
 		return t, nil
 	}
 	c := C.ffi_type{}
+	g_types_pinner.Pin(&c)
 	t := &cffi_ptr{
 		cffi_type: cffi_type{n: n, c: &c},
 		elem:      elmt,
@@ -468,6 +473,7 @@ func NewSliceType(elmt Type) (Type, error) {
 		return t, nil
 	}
 	c := C.ffi_type{}
+	g_types_pinner.Pin(&c)
 	t := &cffi_slice{
 		cffi_type: cffi_type{n: n, c: &c},
 		elem:      elmt,
@@ -492,6 +498,7 @@ func NewSliceType(elmt Type) (Type, error) {
 	cargs[3] = nil
 
 	c_fields = &cargs[0]
+	g_types_pinner.Pin(c_fields)
 	C._go_ffi_type_set_elements(t.cptr(), unsafe.Pointer(c_fields))
 
 	// initialize type (computes alignment and size)
@@ -507,6 +514,11 @@ func NewSliceType(elmt Type) (Type, error) {
 // the global map of types
 var g_types map[string]Type
 
+// g_types_pinner pins the ffi_types of the types created at runtime, and
+// their elements, as libffi references them from C: types are never
+// released.
+var g_types_pinner runtime.Pinner
+
 // TypeByName returns a ffi.Type by name.
 // Returns nil if no such type exists
 func TypeByName(n string) Type {
@@ -704,6 +716,34 @@ func is_compatible(t1, t2 Type) bool {
 	return true
 }
 
+// same_layout returns whether the values of type t1 can be copied as values
+// of type t2: same size and kind, with fields (or elements) of the same
+// layout.
+func same_layout(t1, t2 Type) bool {
+	if t1 == nil || t2 == nil {
+		return false
+	}
+	if t1.Kind() != t2.Kind() || t1.Size() != t2.Size() {
+		return false
+	}
+	switch t1.Kind() {
+	case Struct:
+		if t1.NumField() != t2.NumField() {
+			return false
+		}
+		for i := 0; i < t1.NumField(); i++ {
+			f1 := t1.Field(i)
+			f2 := t2.Field(i)
+			if f1.Offset != f2.Offset || !same_layout(f1.Type, f2.Type) {
+				return false
+			}
+		}
+	case Array:
+		return t1.Len() == t2.Len() && same_layout(t1.Elem(), t2.Elem())
+	}
+	return true
+}
+
 func init() {
 	// init out id counter channel
 	g_id_ch = make(chan int, 1)
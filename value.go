@@ -9,30 +9,14 @@ import (
 
 const ptrSize = unsafe.Sizeof((*byte)(nil))
 
-// TODO: This will have to go away when
-// the new gc goes in.
+// memmove copies n bytes from asrc to adst, which may overlap.
+// It goes through byte slices rather than pointer arithmetic, so that
+// checkptr accepts it.
 func memmove(adst, asrc unsafe.Pointer, n uintptr) {
-	dst := uintptr(adst)
-	src := uintptr(asrc)
-	switch {
-	case src < dst && src+n > dst:
-		// byte copy backward
-		// careful: i is unsigned
-		for i := n; i > 0; {
-			i--
-			*(*byte)(unsafe.Pointer(dst + i)) = *(*byte)(unsafe.Pointer(src + i))
-		}
-	case (n|src|dst)&(ptrSize-1) != 0:
-		// byte copy forward
-		for i := uintptr(0); i < n; i++ {
-			*(*byte)(unsafe.Pointer(dst + i)) = *(*byte)(unsafe.Pointer(src + i))
-		}
-	default:
-		// word copy forward
-		for i := uintptr(0); i < n; i += ptrSize {
-			*(*uintptr)(unsafe.Pointer(dst + i)) = *(*uintptr)(unsafe.Pointer(src + i))
-		}
+	if n == 0 {
+		return
 	}
+	copy(unsafe.Slice((*byte)(adst), n), unsafe.Slice((*byte)(asrc), n))
 }
 
 // methodName returns the name of the calling method,
@@ -68,6 +52,9 @@ type Value struct {
 
 	// val points at the value of this Value.
 	val unsafe.Pointer
+
+	// lib keeps the library of a variable loaded, see Library.Var.
+	lib *lib_ref
 }
 
 // New returns a Value representing a pointer to a new zero value for
@@ -94,7 +81,7 @@ func NewAt(typ Type, p unsafe.Pointer) Value {
 		return Value{}
 	}
 
-	v := Value{typ, p}
+	v := Value{typ, p, nil}
 	return v
 }
 
@@ -115,7 +102,7 @@ func (v Value) Addr() Value {
 		return Value{}
 	}
 	ptr := unsafe.Pointer(&v.val)
-	return Value{typ, ptr}
+	return Value{typ, ptr, nil}
 }
 
 // Buffer returns the underlying byte storage for this value.
@@ -172,7 +159,7 @@ func (v Value) Field(i int) Value {
 	var val unsafe.Pointer
 	// Indirect.  Just bump pointer.
 	val = unsafe.Pointer(uintptr(v.val) + field.Offset)
-	return Value{typ, val}
+	return Value{typ, val, v.lib}
 }
 
 // FieldByIndex returns the nested field corresponding to index.
@@ -290,7 +277,7 @@ func (v Value) Index(i int) Value {
 		offset := uintptr(i) * typ.Size()
 
 		var val unsafe.Pointer = unsafe.Pointer(uintptr(v.val) + offset)
-		return Value{typ, val}
+		return Value{typ, val, v.lib}
 	case Slice:
 		s := (*reflect.SliceHeader)(v.val)
 		if i < 0 || i >= s.Len {
@@ -299,8 +286,9 @@ func (v Value) Index(i int) Value {
 		tt := v.typ.(*cffi_slice)
 		typ := tt.Elem()
 		offset := uintptr(i) * typ.Size()
-		val := unsafe.Pointer(s.Data + offset)
-		return Value{typ, val}
+		// the data pointer of the header, read as a pointer for checkptr
+		val := unsafe.Add(*(*unsafe.Pointer)(v.val), offset)
+		return Value{typ, val, nil}
 	}
 	panic(&ValueError{"ffi.Value.Index", k})
 }
@@ -380,10 +368,7 @@ func (v *Value) set_field(i int, f Value) {
 
 	// fmt.Printf(":: v=0x%x i=%d f=0x%x...\n", v.UnsafeAddr(), i, f.UnsafeAddr())
 	vv := v.Field(i)
-	memmove(
-		unsafe.Pointer(vv.UnsafeAddr()),
-		unsafe.Pointer(f.UnsafeAddr()),
-		vv.typ.Size())
+	memmove(vv.val, f.val, vv.typ.Size())
 }
 
 // SetValue assigns x to the value v.
@@ -547,7 +532,7 @@ func (v Value) Slice(beg, end int) Value {
 	case Slice:
 		typ = v.typ.(*cffi_slice)
 		s := (*reflect.SliceHeader)(v.val)
-		base = unsafe.Pointer(s.Data)
+		base = *(*unsafe.Pointer)(v.val) // s.Data
 		cap = s.Cap
 
 	}
@@ -564,7 +549,7 @@ func (v Value) Slice(beg, end int) Value {
 	s.Len = end - beg
 	s.Cap = cap - beg
 
-	return Value{typ, unsafe.Pointer(&x)}
+	return Value{typ, unsafe.Pointer(&x), nil}
 }
 
 // Type returns v's type
@@ -729,7 +714,7 @@ func MakeSlice(typ Type, vlen, vcap int) Value {
 	//s.Len = vlen
 	//s.Cap = vcap
 
-	return Value{typ, unsafe.Pointer(&x)}
+	return Value{typ, unsafe.Pointer(&x), nil}
 }
 
 // grow_slice grows the slice s so that it can hold extra more values,
@@ -0,0 +1,55 @@
+package ffi_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/gonuts/ffi"
+)
+
+func TestLibraryVar(t *testing.T) {
+	lib, err := ffi.NewLibrary(libc_name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer lib.Close()
+
+	//extern int optind;
+	optind, err := lib.Var("optind", ffi.C_int32)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	old := optind.Int()
+	defer optind.SetInt(old)
+
+	optind.SetInt(42)
+	// the Value aliases the variable
+	optind2, err := lib.Var("optind", ffi.C_int32)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eq(t, int64(42), optind2.Int())
+	eq(t, optind.UnsafeAddr(), optind2.UnsafeAddr())
+
+	if runtime.GOOS == "linux" {
+		//extern char **environ;
+		environ, err := lib.Var("environ", ffi.C_pointer)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if environ.IsNil() {
+			t.Errorf("expected a non-nil environ")
+		}
+	}
+
+	_, err = lib.Var("no_such_ffi_variable", ffi.C_int32)
+	if err == nil {
+		t.Errorf("expected an error for a missing variable")
+	}
+	_, err = ffi.NewMockLibrary().Var("optind", ffi.C_int32)
+	if err == nil {
+		t.Errorf("expected an error for a variable of a mock library")
+	}
+}
+
+// EOF